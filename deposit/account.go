@@ -0,0 +1,120 @@
+package deposit
+
+import (
+	"sort"
+	"time"
+)
+
+// An Account holds the deposits a customer has made in a single currency. When deposits
+// are converted into a base currency the customer also has a combined account with an
+// empty currency.
+type Account struct {
+	CustomerID string
+	Currency   string
+
+	// The limits that apply to the account
+	Limits Limits
+
+	// Ledgers for the day, week and month of the deposit being validated
+	Daily   Ledger
+	Weekly  Ledger
+	Monthly Ledger
+
+	// The accepted deposits that can still affect a decision, oldest first
+	History []Entry
+
+	// The time of the latest deposit made into the account
+	Latest time.Time
+}
+
+// Usage is a customer's use of the limits of one of their accounts
+type Usage struct {
+	Currency string
+
+	// Whether this is the account of the customer's deposits in every currency, converted
+	// into the base currency
+	Combined bool
+
+	Limits  Limits
+	Daily   Ledger
+	Weekly  Ledger
+	Monthly Ledger
+}
+
+// A Ledger records the deposits accepted into an account during one period
+type Ledger struct {
+	Start    time.Time
+	Deposits int
+	Total    Money
+}
+
+// An Entry is a deposit accepted into an account
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Amount Money     `json:"amount"`
+}
+
+// A Load is a deposit being made into an account, with the amount in the account currency
+type Load struct {
+	Deposit *Deposit
+	Amount  Money
+}
+
+// prepare fills in the account's ledgers for the periods of the customer's calendar that
+// contain the deposit time. Later deposits in the same period are included so that a
+// deposit which arrives late is held to the same limits as the others.
+func (a *Account) prepare(t time.Time, c calendar) {
+	day := c.startOfDay(t)
+	a.Daily = a.period(day, day.AddDate(0, 0, 1))
+
+	week := c.startOfWeek(t)
+	a.Weekly = a.period(week, week.AddDate(0, 0, 7))
+
+	month := c.startOfMonth(t)
+	a.Monthly = a.period(month, month.AddDate(0, 1, 0))
+}
+
+// period returns a ledger of the deposits accepted from the start of a period until the end
+func (a *Account) period(start time.Time, end time.Time) Ledger {
+	ledger := Ledger{Start: start}
+
+	for _, entry := range a.History {
+		if !entry.Time.Before(start) && entry.Time.Before(end) {
+			ledger.Deposits++
+			ledger.Total += entry.Amount
+		}
+	}
+
+	return ledger
+}
+
+// Window returns a ledger of the deposits accepted after the start of a window, up to and
+// including the end
+func (a *Account) Window(start time.Time, end time.Time) Ledger {
+	ledger := Ledger{Start: start}
+
+	for _, entry := range a.History {
+		if entry.Time.After(start) && !entry.Time.After(end) {
+			ledger.Deposits++
+			ledger.Total += entry.Amount
+		}
+	}
+
+	return ledger
+}
+
+// record adds an accepted load to the account's history, keeping it in chronological order
+func (a *Account) record(load Load) {
+	entry := Entry{load.Deposit.Time, load.Amount}
+
+	i := sort.Search(len(a.History), func(i int) bool { return a.History[i].Time.After(entry.Time) })
+	a.History = append(a.History, Entry{})
+	copy(a.History[i+1:], a.History[i:])
+	a.History[i] = entry
+}
+
+// prune forgets deposits made before the cutoff
+func (a *Account) prune(cutoff time.Time) {
+	i := sort.Search(len(a.History), func(i int) bool { return !a.History[i].Time.Before(cutoff) })
+	a.History = a.History[i:]
+}
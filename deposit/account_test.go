@@ -0,0 +1,145 @@
+package deposit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidate_OutOfOrder(t *testing.T) {
+	policy := DefaultPolicy()
+	policy.Lateness = 48 * time.Hour
+	limits := policy.Currencies["USD"]
+	limits.Rolling = []RollingLimit{{Window: 24 * time.Hour, Amount: 6000 * Dollar}}
+	policy.Currencies["USD"] = limits
+
+	v := NewValidatorWithPolicy(policy)
+
+	t.Run("Validate should not reset the current day for a deposit on an earlier day", func(t *testing.T) {
+		first := newDeposit("1", "1", "$4000.00", time.Date(2021, 1, 6, 10, 0, 0, 0, time.UTC))
+		late := newDeposit("2", "1", "$1000.00", time.Date(2021, 1, 5, 10, 0, 0, 0, time.UTC))
+		second := newDeposit("3", "1", "$1000.00", time.Date(2021, 1, 6, 11, 0, 0, 0, time.UTC))
+		third := newDeposit("4", "1", "$0.01", time.Date(2021, 1, 6, 12, 0, 0, 0, time.UTC))
+		assert.True(t, v.Validate(&first).Accepted)
+		assert.True(t, v.Validate(&late).Accepted)
+		assert.True(t, v.Validate(&second).Accepted)
+		assert.Equal(t, []Reason{DailyAmountExceeded}, v.Validate(&third).Reasons)
+	})
+
+	t.Run("Validate should include later deposits in the late deposit's day", func(t *testing.T) {
+		late := newDeposit("5", "1", "$4000.00", time.Date(2021, 1, 6, 9, 0, 0, 0, time.UTC))
+		assert.Equal(t, []Reason{DailyAmountExceeded, RollingAmountExceeded}, v.Validate(&late).Reasons)
+	})
+
+	t.Run("Validate should include later deposits in the late deposit's rolling windows", func(t *testing.T) {
+		// The 24 hours ending with the $4000.00 deposit on the 6th would include this one
+		late := newDeposit("6", "1", "$2000.01", time.Date(2021, 1, 5, 11, 0, 0, 0, time.UTC))
+		assert.Equal(t, []Reason{RollingAmountExceeded}, v.Validate(&late).Reasons)
+
+		early := newDeposit("7", "1", "$1000.01", time.Date(2021, 1, 5, 9, 0, 0, 0, time.UTC))
+		assert.True(t, v.Validate(&early).Accepted)
+	})
+
+	t.Run("Validate should reject deposits later than the lateness", func(t *testing.T) {
+		// The latest deposit accepted was at 11:00 on the 6th
+		deposit := newDeposit("8", "1", "$1.00", time.Date(2021, 1, 4, 10, 59, 59, 0, time.UTC))
+		assert.Equal(t, []Reason{DepositTooLate}, v.Validate(&deposit).Reasons)
+	})
+
+	t.Run("Validate should not count deposits as late because of a declined deposit", func(t *testing.T) {
+		v := NewValidatorWithPolicy(policy)
+
+		first := newDeposit("1", "3", "$100.00", time.Date(2021, 1, 6, 10, 0, 0, 0, time.UTC))
+		declined := newDeposit("2", "3", "$6000.00", time.Date(2021, 1, 20, 10, 0, 0, 0, time.UTC))
+		second := newDeposit("3", "3", "$100.00", time.Date(2021, 1, 7, 10, 0, 0, 0, time.UTC))
+		assert.True(t, v.Validate(&first).Accepted)
+		assert.False(t, v.Validate(&declined).Accepted)
+		assert.True(t, v.Validate(&second).Accepted)
+	})
+
+	t.Run("Validate should keep the history needed for late deposits", func(t *testing.T) {
+		// Move the customer into the next month, with a week that began in the last one
+		for i, day := range []int{29, 30, 31} {
+			deposit := newDeposit(fmt.Sprint(i), "2", "$5000.00", time.Date(2021, 3, day, 0, 0, 0, 0, time.UTC))
+			assert.True(t, v.Validate(&deposit).Accepted)
+		}
+
+		april := newDeposit("3", "2", "$5000.00", time.Date(2021, 4, 3, 0, 0, 0, 0, time.UTC))
+		late := newDeposit("4", "2", "$0.01", time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC))
+		assert.True(t, v.Validate(&april).Accepted)
+		assert.Equal(t, []Reason{WeeklyAmountExceeded}, v.Validate(&late).Reasons)
+	})
+}
+
+func TestValidate_InOrder(t *testing.T) {
+	policy := DefaultPolicy()
+	policy.Lateness = 0
+
+	v := NewValidatorWithPolicy(policy)
+
+	t.Run("Validate should reject any deposit earlier than the latest without a lateness", func(t *testing.T) {
+		first := newDeposit("1", "1", "$1.00", time.Date(2021, 1, 6, 10, 0, 0, 0, time.UTC))
+		second := newDeposit("2", "1", "$1.00", time.Date(2021, 1, 6, 10, 0, 0, 0, time.UTC))
+		late := newDeposit("3", "1", "$1.00", time.Date(2021, 1, 6, 9, 59, 59, 0, time.UTC))
+		assert.True(t, v.Validate(&first).Accepted)
+		assert.True(t, v.Validate(&second).Accepted)
+		assert.Equal(t, []Reason{DepositTooLate}, v.Validate(&late).Reasons)
+	})
+}
+
+func TestAccount_Prune(t *testing.T) {
+	t.Run("prune should forget deposits before the cutoff", func(t *testing.T) {
+		base := time.Date(2021, 1, 6, 0, 0, 0, 0, time.UTC)
+		account := Account{History: []Entry{{base, 1}, {base.Add(time.Hour), 2}, {base.Add(2 * time.Hour), 3}}}
+
+		account.prune(base.Add(time.Hour))
+		assert.Equal(t, []Entry{{base.Add(time.Hour), 2}, {base.Add(2 * time.Hour), 3}}, account.History)
+	})
+}
+
+func TestValidate_Usage(t *testing.T) {
+	rates := NewStaticRates()
+	rates.Add("EUR", "USD", mustParseRate(t, "1.25"))
+
+	policy := DefaultPolicy()
+	policy.BaseCurrency = "USD"
+	policy.Rates = rates
+	v := NewValidatorWithPolicy(policy)
+
+	for i, amount := range []string{"$100.00", "€100.00", "$50.00"} {
+		deposit := newDeposit(fmt.Sprint(i), "1", amount, time.Date(2021, 1, 9+i, 10, 0, 0, 0, time.UTC))
+		assert.True(t, v.Validate(&deposit).Accepted)
+	}
+
+	t.Run("Usage should report each account at its latest deposit", func(t *testing.T) {
+		usage, err := v.Usage("1", time.Time{})
+		assert.NoError(t, err)
+
+		assert.Len(t, usage, 3)
+		assert.Equal(t, []string{"EUR", "USD", "USD"}, []string{usage[0].Currency, usage[1].Currency, usage[2].Currency})
+		assert.True(t, usage[2].Combined)
+
+		assert.Equal(t, Ledger{time.Date(2021, 1, 10, 0, 0, 0, 0, time.UTC), 1, 100 * Dollar}, usage[0].Daily)
+		assert.Equal(t, Ledger{time.Date(2021, 1, 11, 0, 0, 0, 0, time.UTC), 1, 50 * Dollar}, usage[1].Daily)
+		assert.Equal(t, Ledger{time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC), 1, 100 * Dollar}, usage[0].Weekly)
+		assert.Equal(t, Ledger{time.Date(2021, 1, 11, 0, 0, 0, 0, time.UTC), 1, 50 * Dollar}, usage[2].Weekly)
+		assert.Equal(t, policy.Currencies["USD"], usage[1].Limits)
+	})
+
+	t.Run("Usage should report the periods containing the given time", func(t *testing.T) {
+		usage, err := v.Usage("1", time.Date(2021, 1, 9, 23, 0, 0, 0, time.UTC))
+		assert.NoError(t, err)
+
+		assert.Equal(t, Ledger{time.Date(2021, 1, 9, 0, 0, 0, 0, time.UTC), 1, 100 * Dollar}, usage[1].Daily)
+		assert.Equal(t, Ledger{time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC), 1, 100 * Dollar}, usage[1].Weekly)
+		assert.Equal(t, Ledger{time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), 3, 275 * Dollar}, usage[2].Monthly)
+	})
+
+	t.Run("Usage should report nothing for unknown customers", func(t *testing.T) {
+		usage, err := v.Usage("2", time.Time{})
+		assert.NoError(t, err)
+		assert.Empty(t, usage)
+	})
+}
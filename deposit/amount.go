@@ -0,0 +1,224 @@
+package deposit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The reasons an amount does not match the grammar, wrapped in an AmountError
+var (
+	ErrEmptyAmount        = errors.New("no digits")
+	ErrSignedAmount       = errors.New("amounts cannot have a sign")
+	ErrInvalidCharacter   = errors.New("unexpected character")
+	ErrLeadingZero        = errors.New("leading zeros are not allowed")
+	ErrMisplacedSeparator = errors.New("misplaced separator")
+	ErrTooManyDecimals    = errors.New("more decimal places than the currency has")
+	ErrAmountOutOfRange   = errors.New("out of range")
+)
+
+// An AmountError reports why an amount could not be parsed. Err is one of the errors above.
+type AmountError struct {
+	Amount string
+	Err    error
+}
+
+func (e *AmountError) Error() string {
+	return fmt.Sprintf("invalid amount %q: %v", e.Amount, e.Err)
+}
+
+func (e *AmountError) Unwrap() error {
+	return e.Err
+}
+
+// A Locale is the way a region writes numbers. Groups of thousands are not separated if
+// Thousands is zero.
+type Locale struct {
+	Decimal   rune
+	Thousands rune
+}
+
+// Common locales
+var (
+	// 1,234.56
+	EnglishLocale = Locale{Decimal: '.', Thousands: ','}
+
+	// 1.234,56
+	GermanLocale = Locale{Decimal: ',', Thousands: '.'}
+
+	// 1'234.56
+	SwissLocale = Locale{Decimal: '.', Thousands: '\''}
+)
+
+// ParseAmount parses an amount written in the English locale that may be prefixed with a
+// currency symbol or ISO 4217 code, such as "$12.00", "€1,200.00" or "EUR 12.00". The
+// returned currency is empty if the amount did not have a prefix.
+func ParseAmount(s string) (Money, Currency, error) {
+	return ParseAmountLocale(s, EnglishLocale)
+}
+
+// ParseAmountLocale parses an amount written in the locale. Amounts follow the grammar
+//
+//	amount   = [ symbol | code [ " " ] ] number
+//	number   = whole [ decimal fraction ]
+//	whole    = "0" | nonzero { digit } | nonzero [ digit [ digit ] ] thousands group { thousands group }
+//	group    = digit digit digit
+//	fraction = digit { digit }
+//
+// where decimal and thousands are the locale's separators, and the fraction has at most as
+// many digits as the currency has minor units. Amounts without a prefix are in the default
+// currency. Anything else, including signs, exponents, hexadecimal and the names of special
+// floating point values, is rejected with an AmountError, or an UnsupportedCurrencyError
+// if the code is not known.
+func ParseAmountLocale(s string, locale Locale) (Money, Currency, error) {
+	return parseAmount(s, locale, currencies[DefaultCurrency])
+}
+
+// parseAmount parses an amount, limiting amounts without a prefix to the decimal places of
+// the fallback currency
+func parseAmount(s string, locale Locale, fallback Currency) (Money, Currency, error) {
+	currency, number, err := splitCurrency(s)
+	if err != nil {
+		return 0, Currency{}, err
+	}
+
+	minorUnits := fallback.MinorUnits
+	if currency.Code != "" {
+		minorUnits = currency.MinorUnits
+	}
+
+	money, err := parseNumber(number, locale, minorUnits)
+	if err != nil {
+		return 0, Currency{}, &AmountError{Amount: s, Err: err}
+	}
+
+	return money, currency, nil
+}
+
+// splitCurrency splits the currency symbol or code from the start of an amount
+func splitCurrency(s string) (Currency, string, error) {
+	for _, currency := range currencies {
+		if strings.HasPrefix(s, currency.Symbol) {
+			return currency, strings.TrimPrefix(s, currency.Symbol), nil
+		}
+	}
+
+	// Otherwise the amount may start with a three letter code
+	letters := strings.TrimLeftFunc(s, isLetter)
+	code := s[:len(s)-len(letters)]
+
+	if code == "" {
+		return Currency{}, s, nil
+	}
+
+	if len(code) != 3 || strings.ToUpper(code) != code {
+		return Currency{}, "", &AmountError{Amount: s, Err: ErrInvalidCharacter}
+	}
+
+	currency, err := LookupCurrency(code)
+	if err != nil {
+		return Currency{}, "", err
+	}
+
+	return currency, strings.TrimPrefix(letters, " "), nil
+}
+
+// parseNumber parses the number of an amount into Money. The fraction can have at most as
+// many digits as the currency has minor units, and no more than Money has.
+func parseNumber(number string, locale Locale, minorUnits int) (Money, error) {
+	if number == "" {
+		return 0, ErrEmptyAmount
+	}
+
+	if number[0] == '-' || number[0] == '+' {
+		return 0, ErrSignedAmount
+	}
+
+	whole, fraction := number, ""
+	hasFraction := false
+	if i := strings.IndexRune(number, locale.Decimal); i >= 0 {
+		whole, fraction = number[:i], number[i+utf8.RuneLen(locale.Decimal):]
+		hasFraction = true
+	}
+
+	for _, r := range whole {
+		if !isDigit(r) && (r != locale.Thousands || r == 0) {
+			return 0, ErrInvalidCharacter
+		}
+	}
+
+	for _, r := range fraction {
+		if r == locale.Decimal || (r == locale.Thousands && r != 0) {
+			return 0, ErrMisplacedSeparator
+		}
+
+		if !isDigit(r) {
+			return 0, ErrInvalidCharacter
+		}
+	}
+
+	if whole == "" && !hasFraction {
+		return 0, ErrEmptyAmount
+	}
+
+	if whole == "" || (hasFraction && fraction == "") {
+		return 0, ErrMisplacedSeparator
+	}
+
+	digits, ok := ungroup(whole, locale.Thousands)
+	if !ok {
+		return 0, ErrMisplacedSeparator
+	}
+
+	if len(digits) > 1 && digits[0] == '0' {
+		return 0, ErrLeadingZero
+	}
+
+	if len(fraction) > minorUnits || len(fraction) > moneyDecimals {
+		return 0, ErrTooManyDecimals
+	}
+
+	units, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || units > math.MaxInt64/int64(Dollar)-1 {
+		return 0, ErrAmountOutOfRange
+	}
+
+	// Pad the fraction to the decimal places of Money so that ".5" is read as fifty cents,
+	// whatever the minor units of the currency
+	cents, _ := strconv.ParseInt(fraction+strings.Repeat("0", moneyDecimals-len(fraction)), 10, 64)
+
+	return Money(units)*Dollar + Money(cents), nil
+}
+
+// ungroup removes the separators from a whole number, reporting false unless every group
+// after the first has three digits
+func ungroup(whole string, thousands rune) (string, bool) {
+	if thousands == 0 || !strings.ContainsRune(whole, thousands) {
+		return whole, true
+	}
+
+	groups := strings.Split(whole, string(thousands))
+
+	if len(groups[0]) < 1 || len(groups[0]) > 3 {
+		return "", false
+	}
+
+	for _, group := range groups[1:] {
+		if len(group) != 3 {
+			return "", false
+		}
+	}
+
+	return strings.Join(groups, ""), true
+}
+
+func isDigit(r rune) bool {
+	return r >= '0' && r <= '9'
+}
+
+func isLetter(r rune) bool {
+	return (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z')
+}
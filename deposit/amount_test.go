@@ -0,0 +1,223 @@
+package deposit
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAmountLocale(t *testing.T) {
+	t.Run("ParseAmount should parse amounts that match the grammar", func(t *testing.T) {
+		cases := map[string]Money{
+			"$0":            0,
+			"$0.5":          50,
+			"$1234.56":      123456,
+			"$1,234.56":     123456,
+			"$12,345,678":   1234567800,
+			"€999,999.99":   99999999,
+			"EUR 1,000":     100000,
+			"GBP1000.01":    100001,
+			"1000000000.00": 100000000000,
+		}
+
+		for input, expected := range cases {
+			money, _, err := ParseAmount(input)
+			assert.NoError(t, err, input)
+			assert.Equal(t, expected, money, input)
+		}
+	})
+
+	t.Run("ParseAmountLocale should use the locale's separators", func(t *testing.T) {
+		money, currency, err := ParseAmountLocale("€1.234,56", GermanLocale)
+		assert.NoError(t, err)
+		assert.Equal(t, Money(123456), money)
+		assert.Equal(t, "EUR", currency.Code)
+
+		money, _, err = ParseAmountLocale("1'234.5", SwissLocale)
+		assert.NoError(t, err)
+		assert.Equal(t, Money(123450), money)
+
+		_, _, err = ParseAmountLocale("1,234.56", GermanLocale)
+		assert.True(t, errors.Is(err, ErrMisplacedSeparator))
+	})
+
+	t.Run("ParseAmount should return the reason an amount does not match the grammar", func(t *testing.T) {
+		cases := map[string]error{
+			"":                      ErrEmptyAmount,
+			"$":                     ErrEmptyAmount,
+			"EUR ":                  ErrEmptyAmount,
+			"$-5.00":                ErrSignedAmount,
+			"+5.00":                 ErrSignedAmount,
+			"$NaN":                  ErrInvalidCharacter,
+			"$Inf":                  ErrInvalidCharacter,
+			"Infinity":              ErrInvalidCharacter,
+			"$1e4":                  ErrInvalidCharacter,
+			"$0x1p10":               ErrInvalidCharacter,
+			"$ 12.00":               ErrInvalidCharacter,
+			"12.00 ":                ErrInvalidCharacter,
+			"EU 12.00":              ErrInvalidCharacter,
+			"usd 12.00":             ErrInvalidCharacter,
+			"$١٢":                   ErrInvalidCharacter,
+			"$1.5e2":                ErrInvalidCharacter,
+			"$01.00":                ErrLeadingZero,
+			"$00":                   ErrLeadingZero,
+			"$0,100":                ErrLeadingZero,
+			"$.50":                  ErrMisplacedSeparator,
+			"$12.":                  ErrMisplacedSeparator,
+			"$1.2.3":                ErrMisplacedSeparator,
+			"$1,00.00":              ErrMisplacedSeparator,
+			"$1234,567":             ErrMisplacedSeparator,
+			"$,100":                 ErrMisplacedSeparator,
+			"$100,":                 ErrMisplacedSeparator,
+			"$1.000,00":             ErrMisplacedSeparator,
+			"$1.001":                ErrTooManyDecimals,
+			"$99999999999999999999": ErrAmountOutOfRange,
+		}
+
+		for input, expected := range cases {
+			_, _, err := ParseAmount(input)
+
+			var amountErr *AmountError
+			if assert.True(t, errors.As(err, &amountErr), input) {
+				assert.Equal(t, input, amountErr.Amount)
+				assert.Equal(t, expected, amountErr.Err, input)
+			}
+		}
+	})
+
+	t.Run("parseNumber should limit the decimal places to the currency's minor units", func(t *testing.T) {
+		money, err := parseNumber("1,234", EnglishLocale, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, 1234*Dollar, money)
+
+		_, err = parseNumber("1.5", EnglishLocale, 0)
+		assert.Equal(t, ErrTooManyDecimals, err)
+
+		money, err = parseNumber("1.5", EnglishLocale, 3)
+		assert.NoError(t, err)
+		assert.Equal(t, Dollar+50*Cent, money)
+
+		// Money cannot hold a thousandth, so it is not rounded away
+		_, err = parseNumber("1.234", EnglishLocale, 3)
+		assert.Equal(t, ErrTooManyDecimals, err)
+	})
+
+	t.Run("ParseJson should return an AmountError for amounts outside the grammar", func(t *testing.T) {
+		_, err := ParseJson(`{"id":"1","customer_id":"1","load_amount":"$NaN","time":"2000-01-01T00:00:00Z"}`)
+		assert.EqualError(t, err, `invalid amount "$NaN": unexpected character`)
+	})
+}
+
+// The grammar of amounts in the English locale, written independently of the parser
+var amountGrammar = regexp.MustCompile(`^(\$|€|£|(USD|EUR|GBP) ?)?(0|[1-9][0-9]*|[1-9][0-9]{0,2}(,[0-9]{3})+)(\.[0-9]{1,2})?$`)
+
+// The characters random amounts are made of, weighted towards digits and separators
+var amountAlphabet = []string{
+	"0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "0", "1", "5", "9",
+	",", ",", ".", ".", "$", "€", "£", "-", "+", " ", "'", "e", "E", "x", "p",
+	"NaN", "Inf", "USD", "EUR", "GBP", "JPY", "١", " ", "\x00",
+}
+
+func randomAmount(r *rand.Rand) string {
+	var b strings.Builder
+	for n := r.Intn(12); n >= 0; n-- {
+		b.WriteString(amountAlphabet[r.Intn(len(amountAlphabet))])
+	}
+
+	return b.String()
+}
+
+func TestParseAmount_Random(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	t.Run("ParseAmount should only accept amounts in the grammar", func(t *testing.T) {
+		for i := 0; i < 200000; i++ {
+			input := randomAmount(r)
+			money, _, err := ParseAmount(input)
+
+			if !amountGrammar.MatchString(input) {
+				if !assert.Error(t, err, input) {
+					return
+				}
+				continue
+			}
+
+			if !assert.NoError(t, err, input) {
+				return
+			}
+
+			// The value is the digits of the number, with the fraction padded to cents
+			number := amountGrammar.FindStringSubmatch(input)
+			whole := strings.ReplaceAll(number[3], ",", "")
+			fraction := (strings.TrimPrefix(number[5], ".") + "00")[:2]
+			assert.Equal(t, strings.TrimLeft(whole+fraction, "0"), strings.TrimLeft(fmt.Sprint(int64(money)), "0"), input)
+		}
+	})
+
+	t.Run("ParseAmount should accept any amount formatted with or without separators", func(t *testing.T) {
+		for i := 0; i < 10000; i++ {
+			money := Money(r.Int63n(1e15))
+			plain := money.String()
+
+			parsed, _, err := ParseAmount(plain)
+			assert.NoError(t, err, plain)
+			assert.Equal(t, money, parsed, plain)
+
+			grouped := "$" + groupThousands(fmt.Sprint(int64(money/Dollar))) + fmt.Sprintf(".%02d", int64(money%Dollar))
+			parsed, _, err = ParseAmount(grouped)
+			assert.NoError(t, err, grouped)
+			assert.Equal(t, money, parsed, grouped)
+		}
+	})
+}
+
+// groupThousands separates the groups of thousands in a whole number with commas
+func groupThousands(whole string) string {
+	for i := len(whole) - 3; i > 0; i -= 3 {
+		whole = whole[:i] + "," + whole[i:]
+	}
+
+	return whole
+}
+
+func FuzzParseAmount(f *testing.F) {
+	for _, seed := range []string{"$0", "$0.5", "$1,234.56", "€12", "EUR 12.00", "GBP1,000", "12.345", "-$1", "$1e3", "$NaN", "USD  1", "$01", "$1,23"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		money, currency, err := ParseAmount(input)
+
+		// The grammar does not limit the size of amounts
+		if errors.Is(err, ErrAmountOutOfRange) {
+			return
+		}
+
+		if !amountGrammar.MatchString(input) {
+			assert.Error(t, err, input)
+			return
+		}
+
+		if !assert.NoError(t, err, input) {
+			return
+		}
+
+		if currency.Code == "" {
+			currency = currencies[DefaultCurrency]
+		}
+
+		// Formatting the amount gives another amount in the grammar with the same value
+		formatted := currency.Format(money)
+		assert.Regexp(t, amountGrammar, formatted, input)
+
+		parsed, parsedCurrency, err := ParseAmount(formatted)
+		assert.NoError(t, err, formatted)
+		assert.Equal(t, money, parsed, formatted)
+		assert.Equal(t, currency.Code, parsedCurrency.Code, formatted)
+	})
+}
@@ -0,0 +1,70 @@
+package deposit
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	// Embed the time zone database so that customer time zones work on any system
+	_ "time/tzdata"
+)
+
+// A calendar divides time into the days, weeks and months that ledgers are kept for
+type calendar struct {
+	location  *time.Location
+	weekStart time.Weekday
+}
+
+// utcCalendar has days that end at midnight UTC and weeks that start on Monday
+var utcCalendar = calendar{time.UTC, time.Monday}
+
+// newCalendar creates a calendar for an IANA time zone such as "America/Toronto" and the
+// name of the day weeks start on. Empty values are taken from the parent calendar.
+func newCalendar(parent calendar, timeZone string, weekStart string) (calendar, error) {
+	c := parent
+
+	if timeZone != "" {
+		location, err := time.LoadLocation(timeZone)
+		if err != nil {
+			return calendar{}, fmt.Errorf("invalid time zone %q", timeZone)
+		}
+
+		c.location = location
+	}
+
+	if weekStart != "" {
+		day, err := parseWeekday(weekStart)
+		if err != nil {
+			return calendar{}, err
+		}
+
+		c.weekStart = day
+	}
+
+	return c, nil
+}
+
+func parseWeekday(name string) (time.Weekday, error) {
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		if strings.EqualFold(name, day.String()) {
+			return day, nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid week start %q", name)
+}
+
+func (c calendar) startOfDay(t time.Time) time.Time {
+	year, month, day := t.In(c.location).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, c.location)
+}
+
+func (c calendar) startOfWeek(t time.Time) time.Time {
+	daysSinceStart := (int(t.In(c.location).Weekday()) - int(c.weekStart) + 7) % 7
+	return c.startOfDay(t).AddDate(0, 0, -daysSinceStart)
+}
+
+func (c calendar) startOfMonth(t time.Time) time.Time {
+	year, month, _ := t.In(c.location).Date()
+	return time.Date(year, month, 1, 0, 0, 0, 0, c.location)
+}
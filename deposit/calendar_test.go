@@ -0,0 +1,86 @@
+package deposit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCalendar(t *testing.T) {
+	tokyo, err := newCalendar(utcCalendar, "Asia/Tokyo", "sunday")
+	assert.NoError(t, err)
+
+	// 23:30 on Saturday the 9th in Tokyo
+	saturday := time.Date(2021, 1, 9, 14, 30, 0, 0, time.UTC)
+
+	t.Run("startOfDay should return local midnight", func(t *testing.T) {
+		assert.Equal(t, time.Date(2021, 1, 9, 15, 0, 0, 0, time.UTC), tokyo.startOfDay(saturday.Add(time.Hour)).UTC())
+		assert.Equal(t, time.Date(2021, 1, 8, 15, 0, 0, 0, time.UTC), tokyo.startOfDay(saturday).UTC())
+	})
+
+	t.Run("startOfWeek should return the start of the configured week", func(t *testing.T) {
+		assert.Equal(t, time.Date(2021, 1, 2, 15, 0, 0, 0, time.UTC), tokyo.startOfWeek(saturday).UTC())
+		assert.Equal(t, time.Date(2021, 1, 9, 15, 0, 0, 0, time.UTC), tokyo.startOfWeek(saturday.Add(time.Hour)).UTC())
+		assert.Equal(t, time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC), utcCalendar.startOfWeek(saturday))
+	})
+
+	t.Run("startOfMonth should return the first of the local month", func(t *testing.T) {
+		assert.Equal(t, time.Date(2020, 12, 31, 15, 0, 0, 0, time.UTC), tokyo.startOfMonth(saturday).UTC())
+	})
+
+	t.Run("newCalendar should return an error for unknown time zones and days", func(t *testing.T) {
+		_, err := newCalendar(utcCalendar, "Mars/Olympus_Mons", "")
+		assert.EqualError(t, err, `invalid time zone "Mars/Olympus_Mons"`)
+
+		_, err = newCalendar(utcCalendar, "", "funday")
+		assert.EqualError(t, err, `invalid week start "funday"`)
+	})
+}
+
+func TestValidate_TimeZones(t *testing.T) {
+	policy := DefaultPolicy()
+	policy.TimeZone = "Asia/Tokyo"
+	policy.Overrides = Overrides{Customers: map[string]CustomerOverride{
+		"2": {TimeZone: "America/New_York"},
+		"3": {WeekStart: "Sunday"},
+	}}
+	assert.NoError(t, policy.Validate())
+
+	v := NewValidatorWithPolicy(policy)
+
+	t.Run("Validate should reset the daily ledger at the policy's local midnight", func(t *testing.T) {
+		first := newDeposit("1", "1", "$5000.00", time.Date(2021, 1, 9, 14, 0, 0, 0, time.UTC))
+		second := newDeposit("2", "1", "$5000.00", time.Date(2021, 1, 9, 15, 0, 0, 0, time.UTC))
+		assert.True(t, v.Validate(&first).Accepted)
+		assert.True(t, v.Validate(&second).Accepted)
+	})
+
+	t.Run("Validate should reset the daily ledger at the customer's local midnight", func(t *testing.T) {
+		first := newDeposit("1", "2", "$5000.00", time.Date(2021, 1, 10, 1, 0, 0, 0, time.UTC))
+		second := newDeposit("2", "2", "$5000.00", time.Date(2021, 1, 10, 4, 59, 59, 0, time.UTC))
+		third := newDeposit("3", "2", "$5000.00", time.Date(2021, 1, 10, 5, 0, 0, 0, time.UTC))
+		assert.True(t, v.Validate(&first).Accepted)
+		assert.Equal(t, []Reason{DailyAmountExceeded}, v.Validate(&second).Reasons)
+		assert.True(t, v.Validate(&third).Accepted)
+	})
+
+	t.Run("Validate should reset the weekly ledger on the customer's week start", func(t *testing.T) {
+		// Saturday the 9th to Sunday the 10th in Tokyo crosses into a new week
+		for day := 4; day <= 8; day++ {
+			deposit := newDeposit(fmt.Sprint(day), "3", "$4000.00", time.Date(2021, 1, day, 0, 0, 0, 0, time.UTC))
+			assert.True(t, v.Validate(&deposit).Accepted)
+		}
+
+		saturday := newDeposit("9", "3", "$1.00", time.Date(2021, 1, 9, 0, 0, 0, 0, time.UTC))
+		sunday := newDeposit("10", "3", "$1.00", time.Date(2021, 1, 9, 15, 0, 0, 0, time.UTC))
+		assert.Equal(t, []Reason{WeeklyAmountExceeded}, v.Validate(&saturday).Reasons)
+		assert.True(t, v.Validate(&sunday).Accepted)
+	})
+
+	t.Run("SetOverrides should return an error for unknown time zones", func(t *testing.T) {
+		err := v.SetOverrides(Overrides{Customers: map[string]CustomerOverride{"1": {TimeZone: "Nowhere"}}})
+		assert.EqualError(t, err, `customer 1 has invalid time zone "Nowhere"`)
+	})
+}
@@ -0,0 +1,50 @@
+package deposit
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultCurrency is assumed for deposits that do not specify a currency
+const DefaultCurrency = "USD"
+
+// A Currency is an ISO 4217 currency that deposits can be made in. Money has two decimal
+// places, so a currency can have at most two minor units.
+type Currency struct {
+	Code   string
+	Symbol string
+
+	// The number of decimal places amounts in the currency can have
+	MinorUnits int
+}
+
+// The currencies that deposits are accepted in
+var currencies = map[string]Currency{
+	"USD": {"USD", "$", 2},
+	"EUR": {"EUR", "€", 2},
+	"GBP": {"GBP", "£", 2},
+}
+
+// UnsupportedCurrencyError is returned when a deposit is made in an unknown currency
+type UnsupportedCurrencyError struct {
+	Code string
+}
+
+func (e *UnsupportedCurrencyError) Error() string {
+	return fmt.Sprintf("unsupported currency %q", e.Code)
+}
+
+// LookupCurrency returns the currency with the given ISO 4217 code
+func LookupCurrency(code string) (Currency, error) {
+	currency, ok := currencies[strings.ToUpper(code)]
+	if !ok {
+		return Currency{}, &UnsupportedCurrencyError{code}
+	}
+
+	return currency, nil
+}
+
+// Format formats the amount with the currency's symbol, e.g. "€12.00"
+func (c Currency) Format(m Money) string {
+	return strings.Replace(m.String(), "$", c.Symbol, 1)
+}
@@ -0,0 +1,106 @@
+package deposit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAmount(t *testing.T) {
+	t.Run("ParseAmount should recognise currency symbols and codes", func(t *testing.T) {
+		cases := map[string]string{
+			"$12.00":    "USD",
+			"€12.00":    "EUR",
+			"£12.00":    "GBP",
+			"EUR 12.00": "EUR",
+			"GBP12.00":  "GBP",
+			"12.00":     "",
+		}
+
+		for input, code := range cases {
+			money, currency, err := ParseAmount(input)
+			assert.NoError(t, err, input)
+			assert.Equal(t, 12*Dollar, money, input)
+			assert.Equal(t, code, currency.Code, input)
+		}
+	})
+
+	t.Run("ParseAmount should return an error for unknown currencies", func(t *testing.T) {
+		_, _, err := ParseAmount("JPY 1200")
+		assert.IsType(t, &UnsupportedCurrencyError{}, err)
+		assert.EqualError(t, err, `unsupported currency "JPY"`)
+	})
+
+	t.Run("ParseAmount should return an error for malformed codes", func(t *testing.T) {
+		_, _, err := ParseAmount("EU 12.00")
+		assert.Error(t, err)
+	})
+}
+
+func TestCurrency_Format(t *testing.T) {
+	t.Run("Format should prefix the amount with the currency symbol", func(t *testing.T) {
+		assert.Equal(t, "€12.50", currencies["EUR"].Format(1250))
+	})
+}
+
+func TestParseJson_Currency(t *testing.T) {
+	t.Run("ParseJson should default to US dollars", func(t *testing.T) {
+		deposit, err := ParseJson(`{"id":"1","customer_id":"1","load_amount":"$1.00","time":"2000-01-01T00:00:00Z"}`)
+		assert.NoError(t, err)
+		assert.Equal(t, "USD", deposit.Currency)
+	})
+
+	t.Run("ParseJson should read the currency field", func(t *testing.T) {
+		deposit, err := ParseJson(`{"id":"1","customer_id":"1","load_amount":"1.00","currency":"gbp","time":"2000-01-01T00:00:00Z"}`)
+		assert.NoError(t, err)
+		assert.Equal(t, "GBP", deposit.Currency)
+		assert.Equal(t, 1*Dollar, deposit.ParsedAmount)
+	})
+
+	t.Run("ParseJson should return an error if the currency field disagrees with the amount", func(t *testing.T) {
+		_, err := ParseJson(`{"id":"1","customer_id":"1","load_amount":"€1.00","currency":"GBP","time":"2000-01-01T00:00:00Z"}`)
+		assert.Error(t, err)
+	})
+
+	t.Run("ParseJson should return an error for unknown currencies", func(t *testing.T) {
+		_, err := ParseJson(`{"id":"1","customer_id":"1","load_amount":"1.00","currency":"XYZ","time":"2000-01-01T00:00:00Z"}`)
+		assert.EqualError(t, err, `unsupported currency "XYZ"`)
+	})
+}
+
+func TestValidate_Currencies(t *testing.T) {
+	tearDownTestCase := setupTestCase(t)
+	defer tearDownTestCase(t)
+
+	t.Run("Validate should keep separate ledgers for each currency", func(t *testing.T) {
+		first := newDeposit("1", "6", "$5000.00", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC))
+		second := newDeposit("2", "6", "€5000.00", time.Date(2021, 1, 9, 11, 0, 0, 0, time.UTC))
+		third := newDeposit("3", "6", "£0.01", time.Date(2021, 1, 9, 12, 0, 0, 0, time.UTC))
+		fourth := newDeposit("4", "6", "€0.01", time.Date(2021, 1, 9, 13, 0, 0, 0, time.UTC))
+		assert.True(t, v.Validate(&first).Accepted)
+		assert.True(t, v.Validate(&second).Accepted)
+		assert.True(t, v.Validate(&third).Accepted)
+		assert.False(t, v.Validate(&fourth).Accepted)
+	})
+
+	t.Run("Validate should apply the limits of the deposit's currency", func(t *testing.T) {
+		v := NewValidatorWithPolicy(Policy{Currencies: map[string]Limits{
+			"GBP": {DailyAmount: 100 * Dollar, WeeklyAmount: 500 * Dollar, MaxDailyDeposits: 3},
+		}})
+
+		first := newDeposit("1", "7", "£100.00", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC))
+		second := newDeposit("2", "7", "£0.01", time.Date(2021, 1, 9, 11, 0, 0, 0, time.UTC))
+		assert.True(t, v.Validate(&first).Accepted)
+		assert.False(t, v.Validate(&second).Accepted)
+	})
+
+	t.Run("Validate should reject currencies without limits", func(t *testing.T) {
+		v := NewValidatorWithPolicy(Policy{Currencies: map[string]Limits{
+			"USD": {DailyAmount: 5000 * Dollar, WeeklyAmount: 20000 * Dollar, MaxDailyDeposits: 3},
+		}})
+
+		deposit := newDeposit("1", "8", "EUR 1.00", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC))
+		assert.False(t, v.Validate(&deposit).Accepted)
+	})
+}
@@ -0,0 +1,147 @@
+package deposit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// A Reason explains why a deposit was rejected
+type Reason string
+
+const (
+	InvalidDeposit       Reason = "INVALID_DEPOSIT"
+	InvalidAmount        Reason = "INVALID_AMOUNT"
+	UnsupportedCurrency  Reason = "UNSUPPORTED_CURRENCY"
+	MissingExchangeRate  Reason = "MISSING_EXCHANGE_RATE"
+	DepositTooLate       Reason = "DEPOSIT_TOO_LATE"
+	DailyCountExceeded   Reason = "DAILY_COUNT_EXCEEDED"
+	DailyAmountExceeded  Reason = "DAILY_AMOUNT_EXCEEDED"
+	WeeklyAmountExceeded Reason = "WEEKLY_AMOUNT_EXCEEDED"
+
+	MonthlyCountExceeded  Reason = "MONTHLY_COUNT_EXCEEDED"
+	MonthlyAmountExceeded Reason = "MONTHLY_AMOUNT_EXCEEDED"
+	RollingCountExceeded  Reason = "ROLLING_COUNT_EXCEEDED"
+	RollingAmountExceeded Reason = "ROLLING_AMOUNT_EXCEEDED"
+
+	StoreUnavailable Reason = "STORE_UNAVAILABLE"
+)
+
+// A Decision is the outcome of validating a deposit
+type Decision struct {
+	ID         string      `json:"id"`
+	CustomerID string      `json:"customer_id"`
+	Accepted   bool        `json:"accepted"`
+	Reasons    []Reason    `json:"reasons,omitempty"`
+	Conversion *Conversion `json:"conversion,omitempty"`
+
+	// Replay is set when the decision was made the first time the deposit was processed
+	Replay bool `json:"replay,omitempty"`
+}
+
+func newDecision(deposit *Deposit) Decision {
+	return Decision{ID: deposit.ID, CustomerID: deposit.CustomerID}
+}
+
+// reject adds the reason to the decision unless it has already been given
+func (d *Decision) reject(reason Reason) {
+	for _, r := range d.Reasons {
+		if r == reason {
+			return
+		}
+	}
+
+	d.Reasons = append(d.Reasons, reason)
+}
+
+// reasonForError returns the reason a deposit with a malformed amount is rejected
+// with. The command line and HTTP server report malformed amounts as errors before
+// validating the deposit, so InvalidAmount only reaches callers of Validate
+func reasonForError(err error) Reason {
+	var unsupported *UnsupportedCurrencyError
+	if errors.As(err, &unsupported) {
+		return UnsupportedCurrency
+	}
+
+	var missingRate *MissingRateError
+	if errors.As(err, &missingRate) {
+		return MissingExchangeRate
+	}
+
+	return InvalidAmount
+}
+
+// A Conversion records how a deposit was converted into the validator's base currency
+type Conversion struct {
+	Currency string
+	Amount   Money
+	Rate     Rate
+}
+
+// MarshalJSON encodes the conversion with the amount formatted in the base currency
+func (c *Conversion) MarshalJSON() ([]byte, error) {
+	currency, err := LookupCurrency(c.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(struct {
+		Currency string `json:"currency"`
+		Amount   string `json:"amount"`
+		Rate     Rate   `json:"rate"`
+	}{currency.Code, currency.Format(c.Amount), c.Rate})
+}
+
+// UnmarshalJSON decodes a conversion encoded by MarshalJSON
+func (c *Conversion) UnmarshalJSON(data []byte) error {
+	var encoded struct {
+		Currency string `json:"currency"`
+		Amount   string `json:"amount"`
+		Rate     Rate   `json:"rate"`
+	}
+
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return err
+	}
+
+	currency, err := LookupCurrency(encoded.Currency)
+	if err != nil {
+		return err
+	}
+
+	amount, amountCurrency, err := ParseAmount(encoded.Amount)
+	if err != nil {
+		return fmt.Errorf("invalid conversion: %w", err)
+	}
+
+	if amountCurrency.Code != currency.Code {
+		return fmt.Errorf("invalid conversion: amount %q is not in %s", encoded.Amount, currency.Code)
+	}
+
+	*c = Conversion{Currency: currency.Code, Amount: amount, Rate: encoded.Rate}
+	return nil
+}
+
+// A ReplayMode is how a deposit that has already been processed is answered
+type ReplayMode string
+
+const (
+	// ReplayEcho answers with the decision made the first time, flagged as a replay
+	ReplayEcho ReplayMode = "echo"
+
+	// ReplaySkip does not answer
+	ReplaySkip ReplayMode = "skip"
+
+	// ReplayError answers with ErrAlreadyProcessed
+	ReplayError ReplayMode = "error"
+)
+
+// ParseReplayMode parses the name of a replay mode
+func ParseReplayMode(s string) (ReplayMode, error) {
+	switch mode := ReplayMode(s); mode {
+	case ReplayEcho, ReplaySkip, ReplayError:
+		return mode, nil
+	}
+
+	return "", fmt.Errorf("unknown replay mode %q", s)
+}
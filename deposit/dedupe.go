@@ -0,0 +1,116 @@
+package deposit
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A DedupeScope is the set of deposits in which load IDs are unique
+type DedupeScope string
+
+const (
+	// Each customer's load IDs are unique, but different customers can use the same IDs
+	CustomerScope DedupeScope = "customer"
+
+	// Load IDs are unique across every customer
+	GlobalScope DedupeScope = "global"
+)
+
+// A DedupeKey identifies a deposit in order to detect duplicates, which have the same key.
+// The customer is left out of the keys of deposits whose IDs are unique across customers.
+type DedupeKey struct {
+	CustomerID string
+	DepositID  string
+}
+
+// String encodes the key so that different keys never have the same encoding, no matter
+// what characters their IDs contain
+func (k DedupeKey) String() string {
+	if k.CustomerID == "" {
+		return strconv.Quote(k.DepositID)
+	}
+
+	return strconv.Quote(k.CustomerID) + "/" + strconv.Quote(k.DepositID)
+}
+
+// dedupeKey returns the key of the deposit in the policy's dedupe scope
+func (p Policy) dedupeKey(deposit *Deposit) DedupeKey {
+	if p.DedupeScope == GlobalScope {
+		return DedupeKey{DepositID: deposit.ID}
+	}
+
+	return DedupeKey{CustomerID: deposit.CustomerID, DepositID: deposit.ID}
+}
+
+// How far the dedupe window has to move before the deposits that fell out of it are
+// forgotten, so that the store is not swept for every deposit
+const forgetInterval = time.Hour
+
+// DedupeStats describe the validated deposits a validator remembers in order to detect
+// duplicates
+type DedupeStats struct {
+	// The number of deposits remembered by the store
+	Remembered int
+
+	// The number of deposits forgotten by the validator
+	Forgotten uint64
+
+	// Deposits made before this time have been forgotten, or none have if it is zero
+	Horizon time.Time
+}
+
+// A dedupeWindow tracks the latest deposit validated, and the time before which deposits
+// have been forgotten
+type dedupeWindow struct {
+	mu        sync.Mutex
+	latest    time.Time
+	horizon   time.Time
+	forgotten uint64
+}
+
+// advance moves the dedupe window up to a deposit that has been validated, forgetting the
+// deposits that fall out of it
+func (v *validator) advance(t time.Time) {
+	if v.policy.DedupeWindow == 0 {
+		return
+	}
+
+	w := &v.dedupe
+	w.mu.Lock()
+
+	if t.After(w.latest) {
+		w.latest = t
+	}
+
+	horizon := w.latest.Add(-v.policy.DedupeWindow)
+	if !w.horizon.IsZero() && horizon.Sub(w.horizon) < forgetInterval {
+		w.mu.Unlock()
+		return
+	}
+
+	// Deposits are only rejected once the store has forgotten them, so if it fails to
+	// forget them they are forgotten by the next sweep instead
+	w.horizon = horizon
+	w.mu.Unlock()
+
+	forgotten, _ := v.store.ForgetValidated(horizon)
+
+	w.mu.Lock()
+	w.forgotten += uint64(forgotten)
+	w.mu.Unlock()
+}
+
+// DedupeStats reports the number of validated deposits remembered and forgotten
+func (v *validator) DedupeStats() (DedupeStats, error) {
+	remembered, err := v.store.CountValidated()
+	if err != nil {
+		return DedupeStats{}, err
+	}
+
+	w := &v.dedupe
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return DedupeStats{Remembered: remembered, Forgotten: w.forgotten, Horizon: w.horizon}, nil
+}
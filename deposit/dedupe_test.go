@@ -0,0 +1,428 @@
+package deposit
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupeWindow(t *testing.T) {
+	policy := DefaultPolicy()
+	policy.DedupeWindow = 72 * time.Hour
+	v := NewValidatorWithPolicy(policy)
+
+	t.Run("Validate should forget deposits that fall out of the window", func(t *testing.T) {
+		assert.Equal(t, []bool{true, true}, validateDeposits(v, "1", 4, 5))
+		assert.Equal(t, []bool{true}, validateDeposits(v, "2", 8))
+
+		stats, err := v.DedupeStats()
+		assert.NoError(t, err)
+		assert.Equal(t, DedupeStats{Remembered: 2, Forgotten: 1, Horizon: time.Date(2021, 1, 5, 10, 0, 0, 0, time.UTC)}, stats)
+
+		forgotten := newDeposit("0104", "1", "$5000.00", time.Date(2021, 1, 4, 10, 0, 0, 0, time.UTC))
+		assert.False(t, v.HasBeenValidated(&forgotten))
+
+		remembered := newDeposit("0105", "1", "$5000.00", time.Date(2021, 1, 5, 10, 0, 0, 0, time.UTC))
+		assert.True(t, v.HasBeenValidated(&remembered))
+	})
+
+	t.Run("ValidateOnce should reject deposits made before the window", func(t *testing.T) {
+		deposit := newDeposit("0104", "1", "$5000.00", time.Date(2021, 1, 4, 10, 0, 0, 0, time.UTC))
+
+		decision, err := v.ValidateOnce(&deposit)
+		assert.NoError(t, err)
+		assert.Equal(t, []Reason{DepositTooLate}, decision.Reasons)
+	})
+
+	t.Run("Validate should only reject deposits the customer may have had forgotten", func(t *testing.T) {
+		v := NewValidatorWithPolicy(policy)
+		assert.Equal(t, []bool{true}, validateDeposits(v, "1", 10))
+
+		// The deposit is made before the window, but none of the customer's have been forgotten
+		assert.Equal(t, []bool{true}, validateDeposits(v, "2", 5))
+
+		// Once the window moves on, the deposit is forgotten and cannot be repeated
+		assert.Equal(t, []bool{true}, validateDeposits(v, "1", 11))
+		repeated := newDeposit("0105", "2", "$5000.00", time.Date(2021, 1, 5, 10, 0, 0, 0, time.UTC))
+		assert.Equal(t, []Reason{DepositTooLate}, v.Validate(&repeated).Reasons)
+		assert.Equal(t, []bool{true}, validateDeposits(v, "2", 6))
+
+		// The deposits forgotten are kept in a snapshot
+		var snap strings.Builder
+		assert.NoError(t, v.Snapshot(&snap))
+
+		restored := NewValidatorWithPolicy(policy)
+		assert.NoError(t, restored.Restore(strings.NewReader(snap.String())))
+		assert.Equal(t, []Reason{DepositTooLate}, restored.Validate(&repeated).Reasons)
+	})
+
+	t.Run("Validate should remember every deposit without a window", func(t *testing.T) {
+		v := NewValidator()
+		assert.Equal(t, []bool{true, true, true}, validateDeposits(v, "1", 4, 11, 18))
+
+		stats, err := v.DedupeStats()
+		assert.NoError(t, err)
+		assert.Equal(t, DedupeStats{Remembered: 3}, stats)
+	})
+
+	t.Run("Validate should return an error for a window shorter than the lateness", func(t *testing.T) {
+		policy := DefaultPolicy()
+		policy.DedupeWindow = time.Hour
+		assert.EqualError(t, policy.Validate(), "dedupe window cannot be shorter than the lateness")
+
+		policy.DedupeWindow = -time.Hour
+		assert.EqualError(t, policy.Validate(), "dedupe window cannot be negative")
+	})
+}
+
+func TestMemoryStore_ForgetValidated(t *testing.T) {
+	base := time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC)
+
+	t.Run("ForgetValidated should keep deposits saved without a time", func(t *testing.T) {
+		s := NewMemoryStore()
+		assert.NoError(t, s.ReadSnapshot(strings.NewReader(`{"version":1,"customers":[{"customer_id":"1","validated":["10"]}]}`)))
+		s.Update("1", func(tx Tx) error { _, err := tx.MarkValidated(DedupeKey{"1", "11"}, base); return err })
+
+		forgotten, err := s.ForgetValidated(base.Add(time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, forgotten)
+
+		count, _ := s.CountValidated()
+		assert.Equal(t, 1, count)
+	})
+}
+
+func TestMemoryStoreWithFilter(t *testing.T) {
+	base := time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC)
+
+	t.Run("NewMemoryStoreWithFilter should return an error for invalid sizes", func(t *testing.T) {
+		_, err := NewMemoryStoreWithFilter(0, 0.01)
+		assert.Error(t, err)
+
+		_, err = NewMemoryStoreWithFilter(1000, 1)
+		assert.Error(t, err)
+	})
+
+	t.Run("MemoryStore should detect duplicates with a filter", func(t *testing.T) {
+		s, err := NewMemoryStoreWithFilter(1000, 0.01)
+		assert.NoError(t, err)
+
+		v := NewValidatorWithStore(DefaultPolicy(), s)
+		deposit := newDeposit("1", "1", "$100.00", base)
+
+		_, err = v.ValidateOnce(&deposit)
+		assert.NoError(t, err)
+
+		_, err = v.ValidateOnce(&deposit)
+		assert.Equal(t, ErrAlreadyProcessed, err)
+	})
+
+	t.Run("MemoryStore should keep close to the false-positive rate with a filter", func(t *testing.T) {
+		const deposits = 10000
+		s, _ := NewMemoryStoreWithFilter(deposits, 0.01)
+
+		for i := 0; i < deposits; i++ {
+			key := DedupeKey{fmt.Sprint(i), fmt.Sprint(i)}
+			s.Update(key.CustomerID, func(tx Tx) error { _, err := tx.MarkValidated(key, base); return err })
+		}
+
+		falsePositives := 0
+		for i := 0; i < deposits; i++ {
+			s.View(fmt.Sprint(i), func(tx Tx) error {
+				if validated, _ := tx.Validated(DedupeKey{fmt.Sprint(i), fmt.Sprint(deposits + i)}); validated {
+					falsePositives++
+				}
+				return nil
+			})
+		}
+
+		assert.Less(t, falsePositives, 2*deposits/100)
+	})
+
+	t.Run("ForgetValidated should forget whole days of deposits in a filter", func(t *testing.T) {
+		s, _ := NewMemoryStoreWithFilter(1000, 0.01)
+		s.Update("1", func(tx Tx) error {
+			tx.MarkValidated(DedupeKey{"1", "1"}, base.Add(-24*time.Hour))
+			_, err := tx.MarkValidated(DedupeKey{"1", "2"}, base)
+			return err
+		})
+
+		// The day of the second deposit has not ended
+		forgotten, err := s.ForgetValidated(base.Add(time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, forgotten)
+
+		s.View("1", func(tx Tx) error {
+			validated, _ := tx.Validated(DedupeKey{"1", "1"})
+			assert.False(t, validated)
+
+			validated, _ = tx.Validated(DedupeKey{"1", "2"})
+			assert.True(t, validated)
+			return nil
+		})
+	})
+
+	t.Run("WriteSnapshot should return an error with a filter", func(t *testing.T) {
+		s, _ := NewMemoryStoreWithFilter(1000, 0.01)
+		assert.Error(t, s.WriteSnapshot(&strings.Builder{}))
+	})
+}
+
+// Keys whose IDs run together the same way when joined with a separator
+var adversarialKeys = [][2]DedupeKey{
+	{{"3", "1-2"}, {"2-3", "1"}},
+	{{"1", "2/3"}, {"1/2", "3"}},
+	{{"1", `"/"2`}, {`1"/"`, "2"}},
+	{{`a\`, `"b`}, {`a\"`, "b"}},
+	{{"", `"1"/"2"`}, {"1", "2"}},
+	{{"", "1-2"}, {"1", "-2"}},
+}
+
+func TestDedupeKey(t *testing.T) {
+	t.Run("String should encode keys whose IDs contain separators differently", func(t *testing.T) {
+		for _, keys := range adversarialKeys {
+			assert.NotEqual(t, keys[0].String(), keys[1].String(), "%#v", keys)
+		}
+	})
+
+	t.Run("String should encode keys without a customer by their ID alone", func(t *testing.T) {
+		assert.Equal(t, `"1"/"2"`, DedupeKey{"1", "2"}.String())
+		assert.Equal(t, `"2"`, DedupeKey{DepositID: "2"}.String())
+	})
+}
+
+// dedupeStores returns a new store of each kind that remembers validated deposits
+func dedupeStores(t *testing.T) map[string]Store {
+	filtered, _ := NewMemoryStoreWithFilter(1000, 0.0001)
+
+	disk, err := OpenDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { disk.Close() })
+
+	return map[string]Store{"memory": NewMemoryStore(), "filter": filtered, "disk": disk}
+}
+
+func TestDedupeScope(t *testing.T) {
+	base := time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC)
+
+	t.Run("ValidateOnce should not confuse IDs that contain separators", func(t *testing.T) {
+		for name, s := range dedupeStores(t) {
+			v := NewValidatorWithStore(DefaultPolicy(), s)
+
+			for _, keys := range adversarialKeys {
+				for _, key := range keys {
+					if key.CustomerID == "" {
+						continue
+					}
+
+					deposit := newDeposit(key.DepositID, key.CustomerID, "$1.00", base)
+					_, err := v.ValidateOnce(&deposit)
+					assert.NoError(t, err, "%s %v", name, key)
+
+					_, err = v.ValidateOnce(&deposit)
+					assert.Equal(t, ErrAlreadyProcessed, err, "%s %v", name, key)
+				}
+			}
+		}
+	})
+
+	t.Run("ValidateOnce should allow customers to reuse IDs in the customer scope", func(t *testing.T) {
+		v := NewValidator()
+
+		for _, customerID := range []string{"1", "2"} {
+			deposit := newDeposit("1", customerID, "$1.00", base)
+			_, err := v.ValidateOnce(&deposit)
+			assert.NoError(t, err)
+		}
+	})
+
+	t.Run("ValidateOnce should reject IDs used by another customer in the global scope", func(t *testing.T) {
+		policy := DefaultPolicy()
+		policy.DedupeScope = GlobalScope
+
+		for name, s := range dedupeStores(t) {
+			v := NewValidatorWithStore(policy, s)
+
+			first := newDeposit("1", "1", "$1.00", base)
+			_, err := v.ValidateOnce(&first)
+			assert.NoError(t, err, name)
+
+			second := newDeposit("1", "2", "$1.00", base)
+			assert.True(t, v.HasBeenValidated(&second), name)
+			_, err = v.ValidateOnce(&second)
+			assert.Equal(t, ErrAlreadyProcessed, err, name)
+
+			// The deposit is not recorded against the second customer
+			usage, _ := v.Usage("2", base)
+			assert.Empty(t, usage, name)
+		}
+	})
+
+	t.Run("ValidateOnce should accept an ID used by many customers at once only once in the global scope", func(t *testing.T) {
+		policy := DefaultPolicy()
+		policy.DedupeScope = GlobalScope
+		v := NewValidatorWithPolicy(policy)
+
+		var mu sync.Mutex
+		validated := 0
+		hammer(50, func(i int) {
+			deposit := newDeposit("1", fmt.Sprint(i), "$1.00", base)
+			if _, err := v.ValidateOnce(&deposit); err == nil {
+				mu.Lock()
+				validated++
+				mu.Unlock()
+			}
+		})
+
+		assert.Equal(t, 1, validated)
+	})
+
+	t.Run("DiskStore should keep IDs from the global scope when it is reopened", func(t *testing.T) {
+		dir := t.TempDir()
+		policy := DefaultPolicy()
+		policy.DedupeScope = GlobalScope
+
+		s, _ := OpenDiskStore(dir)
+		deposit := newDeposit("1", "1", "$1.00", base)
+		NewValidatorWithStore(policy, s).Validate(&deposit)
+		s.log.Close()
+
+		// Replay the log, then read the snapshot taken when the store is closed
+		for i := 0; i < 2; i++ {
+			s, err := OpenDiskStore(dir)
+			assert.NoError(t, err)
+
+			other := newDeposit("1", "2", "$1.00", base)
+			assert.True(t, NewValidatorWithStore(policy, s).HasBeenValidated(&other))
+			assert.NoError(t, s.Close())
+		}
+	})
+
+	t.Run("Validate should return an error for an unknown scope", func(t *testing.T) {
+		policy, err := ParsePolicy(strings.NewReader("dedupe_scope: global\n"))
+		assert.NoError(t, err)
+		assert.Equal(t, GlobalScope, policy.DedupeScope)
+
+		policy = DefaultPolicy()
+		policy.DedupeScope = "customers"
+		assert.EqualError(t, policy.Validate(), `unknown dedupe scope "customers"`)
+	})
+}
+
+func TestReplay(t *testing.T) {
+	base := time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC)
+
+	rates := NewStaticRates()
+	rate, _ := ParseRate("1.25")
+	rates.Add("EUR", "USD", rate)
+
+	policy := DefaultPolicy()
+	policy.BaseCurrency = "USD"
+	policy.Rates = rates
+
+	t.Run("ValidateOnce should return the original decision for a duplicate", func(t *testing.T) {
+		for name, s := range dedupeStores(t) {
+			v := NewValidatorWithStore(DefaultPolicy(), s)
+
+			first := newDeposit("1", "1", "$4000.00", base)
+			second := newDeposit("2", "1", "$4000.00", base.Add(time.Hour))
+			v.ValidateOnce(&first)
+			original, _ := v.ValidateOnce(&second)
+
+			decision, err := v.ValidateOnce(&second)
+			assert.Equal(t, ErrAlreadyProcessed, err, name)
+
+			// Filters only remember that a deposit was validated
+			if name == "filter" {
+				assert.Equal(t, Decision{}, decision)
+				continue
+			}
+
+			original.Replay = true
+			assert.Equal(t, original, decision, name)
+		}
+	})
+
+	t.Run("Validate should not replace the decision saved for a duplicate", func(t *testing.T) {
+		v := NewValidator()
+		deposit := newDeposit("1", "1", "$4000.00", base)
+		v.Validate(&deposit)
+
+		// The second time the deposit would exceed the daily limit
+		assert.False(t, v.Validate(&deposit).Accepted)
+
+		decision, err := v.ValidateOnce(&deposit)
+		assert.Equal(t, ErrAlreadyProcessed, err)
+		assert.True(t, decision.Accepted)
+	})
+
+	t.Run("DiskStore should keep the decisions made when it is reopened", func(t *testing.T) {
+		dir := t.TempDir()
+		deposit := newDeposit("1", "1", "€100.00", base)
+
+		s, _ := OpenDiskStore(dir)
+		original, _ := NewValidatorWithStore(policy, s).ValidateOnce(&deposit)
+		s.log.Close()
+		original.Replay = true
+
+		// Replay the log, then read the snapshot taken when the store is closed
+		for i := 0; i < 2; i++ {
+			s, err := OpenDiskStore(dir)
+			assert.NoError(t, err)
+
+			decision, err := NewValidatorWithStore(policy, s).ValidateOnce(&deposit)
+			assert.Equal(t, ErrAlreadyProcessed, err)
+			assert.Equal(t, original, decision)
+			assert.Equal(t, "$125.00", decision.Conversion.Amount.String())
+			assert.NoError(t, s.Close())
+		}
+	})
+
+	t.Run("ReadSnapshot should restore the decisions made", func(t *testing.T) {
+		v := NewValidatorWithPolicy(policy)
+		deposit := newDeposit("1", "1", "€100.00", base)
+		original, _ := v.ValidateOnce(&deposit)
+		original.Replay = true
+
+		var snap strings.Builder
+		assert.NoError(t, v.Snapshot(&snap))
+
+		restored := NewValidatorWithPolicy(policy)
+		assert.NoError(t, restored.Restore(strings.NewReader(snap.String())))
+
+		decision, err := restored.ValidateOnce(&deposit)
+		assert.Equal(t, ErrAlreadyProcessed, err)
+		assert.Equal(t, original, decision)
+	})
+
+	t.Run("ValidateOnce should not replay the decision of a forgotten deposit", func(t *testing.T) {
+		s := NewMemoryStore()
+		v := NewValidatorWithStore(DefaultPolicy(), s)
+		deposit := newDeposit("1", "1", "$1.00", base)
+		v.ValidateOnce(&deposit)
+
+		s.ForgetValidated(base.Add(time.Hour))
+
+		s.View("1", func(tx Tx) error {
+			decision, err := tx.Decision(DedupeKey{"1", "1"})
+			assert.NoError(t, err)
+			assert.Nil(t, decision)
+			return nil
+		})
+	})
+
+	t.Run("ParseReplayMode should return an error for an unknown mode", func(t *testing.T) {
+		mode, err := ParseReplayMode("echo")
+		assert.NoError(t, err)
+		assert.Equal(t, ReplayEcho, mode)
+
+		_, err = ParseReplayMode("ignore")
+		assert.EqualError(t, err, `unknown replay mode "ignore"`)
+	})
+}
@@ -2,7 +2,9 @@ package deposit
 
 import (
 	"encoding/json"
-	"strconv"
+	"errors"
+	"fmt"
+	"io"
 	"strings"
 	"time"
 )
@@ -12,11 +14,85 @@ type Deposit struct {
 	ID           string    `json:"id"`
 	CustomerID   string    `json:"customer_id"`
 	Amount       string    `json:"load_amount"`
+	Currency     string    `json:"currency"`
 	Time         time.Time `json:"time"`
-	ParsedAmount float64
+	ParsedAmount Money     `json:"-"`
+}
+
+// The fields every deposit payload must include
+var requiredFields = []string{"id", "customer_id", "load_amount", "time"}
+
+// The fields a deposit payload can include, which are described by load.schema.json
+var knownFields = append([]string{"currency"}, requiredFields...)
+
+// The problems a field of a deposit can have, wrapped in a FieldError
+var (
+	ErrFieldMissing   = errors.New("is missing")
+	ErrNotPositive    = errors.New("must be positive")
+	ErrTooFarInFuture = errors.New("is too far in the future")
+)
+
+// A FieldError is a problem with one field of a deposit, named as it is in JSON
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return e.Field + " " + e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// A ValidationError lists every problem with the fields of a deposit
+type ValidationError struct {
+	Fields []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	problems := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		problems[i] = field.Error()
+	}
+
+	return "invalid deposit: " + strings.Join(problems, ", ")
+}
+
+// Problems returns the problem with each invalid field, by the field's name
+func (e *ValidationError) Problems() map[string]string {
+	problems := make(map[string]string, len(e.Fields))
+	for _, field := range e.Fields {
+		problems[field.Field] = field.Err.Error()
+	}
+
+	return problems
+}
+
+// Is reports whether any of the problems is the target, so that errors.Is can find them
+func (e *ValidationError) Is(target error) bool {
+	for _, field := range e.Fields {
+		if errors.Is(field, target) {
+			return true
+		}
+	}
+
+	return false
 }
 
 func ParseJson(depositJson string) (*Deposit, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(depositJson), &fields); err != nil {
+		return nil, err
+	}
+
+	for _, field := range requiredFields {
+		if _, ok := fields[field]; !ok {
+			return nil, fmt.Errorf("missing %s", field)
+		}
+	}
+
 	var deposit Deposit
 
 	if err := json.Unmarshal([]byte(depositJson), &deposit); err != nil {
@@ -30,15 +106,162 @@ func ParseJson(depositJson string) (*Deposit, error) {
 	return &deposit, nil
 }
 
+// ParseJsonStrict parses a deposit like ParseJson, but only accepts payloads that follow
+// load.schema.json exactly. Fields that are not part of a deposit, fields given more than
+// once or as null, field names in the wrong case, currency codes that are not upper case and
+// anything after the deposit are all errors rather than being ignored.
+func ParseJsonStrict(depositJson string) (*Deposit, error) {
+	decoder := json.NewDecoder(strings.NewReader(depositJson))
+	fields, err := readFields(decoder)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after deposit")
+	}
+
+	for _, field := range requiredFields {
+		if _, ok := fields[field]; !ok {
+			return nil, fmt.Errorf("missing %s", field)
+		}
+	}
+
+	var deposit Deposit
+
+	decoder = json.NewDecoder(strings.NewReader(depositJson))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&deposit); err != nil {
+		return nil, err
+	}
+
+	if _, ok := fields["currency"]; ok && (deposit.Currency == "" || strings.ToUpper(deposit.Currency) != deposit.Currency) {
+		return nil, fmt.Errorf("currency %q is not an upper case code", deposit.Currency)
+	}
+
+	if err := deposit.parseAmount(); err != nil {
+		return nil, err
+	}
+
+	return &deposit, nil
+}
+
+// readFields reads the fields of a JSON object, returning an error for any that are unknown,
+// repeated or null
+func readFields(decoder *json.Decoder) (map[string]json.RawMessage, error) {
+	if token, err := decoder.Token(); err != nil {
+		return nil, err
+	} else if token != json.Delim('{') {
+		return nil, errors.New("deposit is not an object")
+	}
+
+	fields := make(map[string]json.RawMessage)
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+
+		name := token.(string)
+		switch {
+		case !isKnownField(name):
+			return nil, fmt.Errorf("unknown field %q", name)
+		case fields[name] != nil:
+			return nil, fmt.Errorf("%s is given more than once", name)
+		case string(value) == "null":
+			return nil, fmt.Errorf("%s cannot be null", name)
+		}
+
+		fields[name] = value
+	}
+
+	// Read the closing brace
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+func isKnownField(name string) bool {
+	for _, field := range knownFields {
+		if field == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Check returns a ValidationError listing every field of the deposit that cannot be used,
+// such as a missing ID or an amount that is not positive. Times more than the allowed skew
+// after now are too far in the future. An amount that cannot be parsed is not reported here,
+// but when the deposit is validated.
+func (deposit *Deposit) Check(now time.Time, skew time.Duration) error {
+	var fields []*FieldError
+	problem := func(field string, err error) {
+		fields = append(fields, &FieldError{Field: field, Err: err})
+	}
+
+	if strings.TrimSpace(deposit.ID) == "" {
+		problem("id", ErrFieldMissing)
+	}
+
+	if strings.TrimSpace(deposit.CustomerID) == "" {
+		problem("customer_id", ErrFieldMissing)
+	}
+
+	if deposit.Amount == "" {
+		problem("load_amount", ErrFieldMissing)
+	} else if amount, _, err := ParseAmount(deposit.Amount); err == nil && amount <= 0 {
+		problem("load_amount", ErrNotPositive)
+	}
+
+	if deposit.Time.IsZero() {
+		problem("time", ErrFieldMissing)
+	} else if deposit.Time.After(now.Add(skew)) {
+		problem("time", ErrTooFarInFuture)
+	}
+
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+
+	return nil
+}
+
 func (deposit *Deposit) parseAmount() error {
-	amount, err := strconv.ParseFloat(strings.TrimPrefix(deposit.Amount, "$"), 64)
+	// Amounts without a symbol or code are in the currency field, or the default currency
+	explicit := currencies[DefaultCurrency]
+	if deposit.Currency != "" {
+		var err error
+		if explicit, err = LookupCurrency(deposit.Currency); err != nil {
+			return err
+		}
+	}
+
+	amount, currency, err := parseAmount(deposit.Amount, EnglishLocale, explicit)
 	if err != nil {
 		// Input wasn't properly formatted
 		return err
 	}
 
+	// The currency field must agree with the symbol or code in the amount if both are given
+	if deposit.Currency != "" && currency.Code != "" && currency.Code != explicit.Code {
+		return fmt.Errorf("load amount %q is not in %s", deposit.Amount, explicit.Code)
+	}
+
 	// Save the amount to the struct so it only has to be calculated once
 	deposit.ParsedAmount = amount
+	deposit.Currency = explicit.Code
+	if currency.Code != "" {
+		deposit.Currency = currency.Code
+	}
 
 	return nil
 }
@@ -1,6 +1,8 @@
 package deposit
 
 import (
+	"errors"
+	"fmt"
 	"testing"
 	"time"
 
@@ -9,6 +11,10 @@ import (
 
 var v Validator
 
+func newDeposit(id string, customerID string, amount string, t time.Time) Deposit {
+	return Deposit{ID: id, CustomerID: customerID, Amount: amount, Time: t}
+}
+
 func setupTestCase(t *testing.T) func(t *testing.T) {
 	// Reset the ledgers and list of validated deposits between tests
 	v = NewValidator()
@@ -23,7 +29,7 @@ func TestHasBeenValidated(t *testing.T) {
 	tearDownTestCase := setupTestCase(t)
 	defer tearDownTestCase(t)
 
-	deposit := Deposit{"1", "1", "$1.00", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC), 0}
+	deposit := newDeposit("1", "1", "$1.00", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC))
 
 	t.Run("HasBeenValidated should return false if the deposit has not been validated", func(t *testing.T) {
 		assert.False(t, v.HasBeenValidated(&deposit))
@@ -40,24 +46,24 @@ func TestValidate_NumberOfDailyDeposits(t *testing.T) {
 	defer tearDownTestCase(t)
 
 	t.Run("Validate should return true if customer deposits less than four times in a day", func(t *testing.T) {
-		first := Deposit{"1", "1", "$1.00", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC), 0}
-		second := Deposit{"2", "1", "$1.00", time.Date(2021, 1, 9, 12, 1, 0, 0, time.UTC), 0}
-		third := Deposit{"3", "1", "$1.00", time.Date(2021, 1, 9, 14, 0, 0, 0, time.UTC), 0}
-		assert.True(t, v.Validate(&first))
-		assert.True(t, v.Validate(&second))
-		assert.True(t, v.Validate(&third))
+		first := newDeposit("1", "1", "$1.00", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC))
+		second := newDeposit("2", "1", "$1.00", time.Date(2021, 1, 9, 12, 1, 0, 0, time.UTC))
+		third := newDeposit("3", "1", "$1.00", time.Date(2021, 1, 9, 14, 0, 0, 0, time.UTC))
+		assert.True(t, v.Validate(&first).Accepted)
+		assert.True(t, v.Validate(&second).Accepted)
+		assert.True(t, v.Validate(&third).Accepted)
 	})
 
 	t.Run("Validate should return false if customer deposits four or more times in a day", func(t *testing.T) {
-		fourth := Deposit{"4", "1", "$1.00", time.Date(2021, 1, 9, 23, 59, 59, 0, time.UTC), 0}
-		fifth := Deposit{"5", "1", "$1.00", time.Date(2021, 1, 9, 23, 59, 59, 1, time.UTC), 0}
-		assert.False(t, v.Validate(&fourth))
-		assert.False(t, v.Validate(&fifth))
+		fourth := newDeposit("4", "1", "$1.00", time.Date(2021, 1, 9, 23, 59, 59, 0, time.UTC))
+		fifth := newDeposit("5", "1", "$1.00", time.Date(2021, 1, 9, 23, 59, 59, 1, time.UTC))
+		assert.False(t, v.Validate(&fourth).Accepted)
+		assert.False(t, v.Validate(&fifth).Accepted)
 	})
 
 	t.Run("Validate should return true once the ledger is reset the next day", func(t *testing.T) {
-		sixth := Deposit{"6", "1", "$1.00", time.Date(2021, 1, 10, 0, 0, 0, 0, time.UTC), 0}
-		assert.True(t, v.Validate(&sixth))
+		sixth := newDeposit("6", "1", "$1.00", time.Date(2021, 1, 10, 0, 0, 0, 0, time.UTC))
+		assert.True(t, v.Validate(&sixth).Accepted)
 	})
 }
 
@@ -66,20 +72,20 @@ func TestValidate_TotalValueOfDailyDeposits(t *testing.T) {
 	defer tearDownTestCase(t)
 
 	t.Run("Validate should return true if customer deposits less than the daily limit in a day", func(t *testing.T) {
-		first := Deposit{"1", "2", "$4000.00", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC), 0}
-		second := Deposit{"2", "2", "$1000.00", time.Date(2021, 1, 9, 11, 0, 0, 0, time.UTC), 0}
-		assert.True(t, v.Validate(&first))
-		assert.True(t, v.Validate(&second))
+		first := newDeposit("1", "2", "$4000.00", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC))
+		second := newDeposit("2", "2", "$1000.00", time.Date(2021, 1, 9, 11, 0, 0, 0, time.UTC))
+		assert.True(t, v.Validate(&first).Accepted)
+		assert.True(t, v.Validate(&second).Accepted)
 	})
 
 	t.Run("Validate should return false if the customer deposits more than the daily limit in a day", func(t *testing.T) {
-		third := Deposit{"3", "2", "$0.01", time.Date(2021, 1, 9, 23, 59, 59, 0, time.UTC), 0}
-		assert.False(t, v.Validate(&third))
+		third := newDeposit("3", "2", "$0.01", time.Date(2021, 1, 9, 23, 59, 59, 0, time.UTC))
+		assert.False(t, v.Validate(&third).Accepted)
 	})
 
 	t.Run("Validate should return true once the ledger is resset the next day", func(t *testing.T) {
-		fourth := Deposit{"4", "2", "$0.01", time.Date(2021, 1, 10, 0, 0, 0, 0, time.UTC), 0}
-		assert.True(t, v.Validate(&fourth))
+		fourth := newDeposit("4", "2", "$0.01", time.Date(2021, 1, 10, 0, 0, 0, 0, time.UTC))
+		assert.True(t, v.Validate(&fourth).Accepted)
 	})
 }
 
@@ -88,24 +94,24 @@ func TestValidate_TotalValueOfWeeklyDeposits(t *testing.T) {
 	defer tearDownTestCase(t)
 
 	t.Run("Validate should return true if customer deposits less than the weekly limit in a week", func(t *testing.T) {
-		first := Deposit{"1", "3", "$5000.00", time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC), 0}
-		second := Deposit{"2", "3", "$5000.00", time.Date(2021, 1, 5, 0, 0, 0, 0, time.UTC), 0}
-		third := Deposit{"3", "3", "$5000.00", time.Date(2021, 1, 6, 0, 0, 0, 0, time.UTC), 0}
-		fourth := Deposit{"4", "3", "$5000.00", time.Date(2021, 1, 7, 0, 0, 0, 0, time.UTC), 0}
-		assert.True(t, v.Validate(&first))
-		assert.True(t, v.Validate(&second))
-		assert.True(t, v.Validate(&third))
-		assert.True(t, v.Validate(&fourth))
+		first := newDeposit("1", "3", "$5000.00", time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC))
+		second := newDeposit("2", "3", "$5000.00", time.Date(2021, 1, 5, 0, 0, 0, 0, time.UTC))
+		third := newDeposit("3", "3", "$5000.00", time.Date(2021, 1, 6, 0, 0, 0, 0, time.UTC))
+		fourth := newDeposit("4", "3", "$5000.00", time.Date(2021, 1, 7, 0, 0, 0, 0, time.UTC))
+		assert.True(t, v.Validate(&first).Accepted)
+		assert.True(t, v.Validate(&second).Accepted)
+		assert.True(t, v.Validate(&third).Accepted)
+		assert.True(t, v.Validate(&fourth).Accepted)
 	})
 
 	t.Run("Validate should return false if the customer deposits more than the weekly limit in a week", func(t *testing.T) {
-		fifth := Deposit{"5", "3", "$0.01", time.Date(2021, 1, 10, 23, 59, 59, 0, time.UTC), 0}
-		assert.False(t, v.Validate(&fifth))
+		fifth := newDeposit("5", "3", "$0.01", time.Date(2021, 1, 10, 23, 59, 59, 0, time.UTC))
+		assert.False(t, v.Validate(&fifth).Accepted)
 	})
 
 	t.Run("Validate should return true once the ledger is reset the next day", func(t *testing.T) {
-		sixth := Deposit{"6", "3", "$0.01", time.Date(2021, 1, 11, 0, 0, 0, 0, time.UTC), 0}
-		assert.True(t, v.Validate(&sixth))
+		sixth := newDeposit("6", "3", "$0.01", time.Date(2021, 1, 11, 0, 0, 0, 0, time.UTC))
+		assert.True(t, v.Validate(&sixth).Accepted)
 	})
 }
 
@@ -114,7 +120,153 @@ func TestValidate_InvalidInput(t *testing.T) {
 	defer tearDownTestCase(t)
 
 	t.Run("Validate should return false if the amount cannot be parsed", func(t *testing.T) {
-		deposit := Deposit{"1", "4", "%5000.00", time.Date(2021, 9, 0, 0, 0, 0, 0, time.UTC), 0}
-		assert.False(t, v.Validate(&deposit))
+		deposit := newDeposit("1", "4", "%5000.00", time.Date(2021, 9, 0, 0, 0, 0, 0, time.UTC))
+		assert.False(t, v.Validate(&deposit).Accepted)
+	})
+}
+
+func TestValidate_Reasons(t *testing.T) {
+	tearDownTestCase := setupTestCase(t)
+	defer tearDownTestCase(t)
+
+	t.Run("Validate should not give reasons for accepted deposits", func(t *testing.T) {
+		deposit := newDeposit("1", "9", "$4000.00", time.Date(2021, 1, 4, 10, 0, 0, 0, time.UTC))
+		assert.Equal(t, Decision{ID: "1", CustomerID: "9", Accepted: true}, v.Validate(&deposit))
+	})
+
+	t.Run("Validate should report every limit that was exceeded", func(t *testing.T) {
+		second := newDeposit("2", "9", "$1.00", time.Date(2021, 1, 4, 11, 0, 0, 0, time.UTC))
+		third := newDeposit("3", "9", "$1.00", time.Date(2021, 1, 4, 12, 0, 0, 0, time.UTC))
+		fourth := newDeposit("4", "9", "$1000.00", time.Date(2021, 1, 4, 13, 0, 0, 0, time.UTC))
+		assert.True(t, v.Validate(&second).Accepted)
+		assert.True(t, v.Validate(&third).Accepted)
+
+		decision := v.Validate(&fourth)
+		assert.False(t, decision.Accepted)
+		assert.Equal(t, []Reason{DailyCountExceeded, DailyAmountExceeded}, decision.Reasons)
+	})
+
+	t.Run("Validate should report the weekly limit", func(t *testing.T) {
+		for day := 5; day <= 7; day++ {
+			deposit := newDeposit(fmt.Sprint(day), "9", "$4000.00", time.Date(2021, 1, day, 10, 0, 0, 0, time.UTC))
+			assert.True(t, v.Validate(&deposit).Accepted)
+		}
+
+		// Bring the weekly total to exactly $20,000.00
+		eighth := newDeposit("8", "9", "$3998.00", time.Date(2021, 1, 8, 10, 0, 0, 0, time.UTC))
+		assert.True(t, v.Validate(&eighth).Accepted)
+
+		deposit := newDeposit("9", "9", "$0.01", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC))
+		assert.Equal(t, []Reason{WeeklyAmountExceeded}, v.Validate(&deposit).Reasons)
+	})
+
+	t.Run("Validate should report malformed amounts", func(t *testing.T) {
+		invalid := newDeposit("10", "9", "$1.2.3", time.Date(2021, 1, 11, 10, 0, 0, 0, time.UTC))
+		assert.Equal(t, []Reason{InvalidAmount}, v.Validate(&invalid).Reasons)
+
+		unsupported := newDeposit("11", "9", "JPY 100", time.Date(2021, 1, 11, 10, 0, 0, 0, time.UTC))
+		assert.Equal(t, []Reason{UnsupportedCurrency}, v.Validate(&unsupported).Reasons)
+	})
+}
+
+func TestParseJson(t *testing.T) {
+	t.Run("ParseJson should return an error for malformed JSON", func(t *testing.T) {
+		_, err := ParseJson(`{"id":"1","customer_id":"1"`)
+		assert.Error(t, err)
+	})
+
+	t.Run("ParseJson should return an error for missing fields", func(t *testing.T) {
+		_, err := ParseJson(`{"id":"1","customer_id":"1","time":"2000-01-01T00:00:00Z"}`)
+		assert.EqualError(t, err, "missing load_amount")
+
+		_, err = ParseJson(`{"customer_id":"1","load_amount":"$1.00","time":"2000-01-01T00:00:00Z"}`)
+		assert.EqualError(t, err, "missing id")
+	})
+
+	t.Run("ParseJson should return an error for unparseable times", func(t *testing.T) {
+		_, err := ParseJson(`{"id":"1","customer_id":"1","load_amount":"$1.00","time":"yesterday"}`)
+		assert.Error(t, err)
+	})
+}
+
+func TestDeposit_Check(t *testing.T) {
+	now := time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC)
+
+	t.Run("Check should accept deposits whose fields can be used", func(t *testing.T) {
+		deposit := newDeposit("1", "1", "$1.00", now.Add(time.Hour))
+		assert.NoError(t, deposit.Check(now, time.Hour))
+	})
+
+	t.Run("Check should report every invalid field", func(t *testing.T) {
+		deposit := newDeposit(" ", "", "$0.00", time.Time{})
+		err := deposit.Check(now, time.Hour)
+		assert.EqualError(t, err, "invalid deposit: id is missing, customer_id is missing, load_amount must be positive, time is missing")
+		assert.True(t, errors.Is(err, ErrFieldMissing))
+		assert.True(t, errors.Is(err, ErrNotPositive))
+		assert.False(t, errors.Is(err, ErrTooFarInFuture))
+
+		var invalid *ValidationError
+		if assert.True(t, errors.As(err, &invalid)) {
+			assert.Equal(t, map[string]string{
+				"id":          "is missing",
+				"customer_id": "is missing",
+				"load_amount": "must be positive",
+				"time":        "is missing",
+			}, invalid.Problems())
+		}
+	})
+
+	t.Run("Check should report times too far in the future", func(t *testing.T) {
+		deposit := newDeposit("1", "1", "$1.00", now.Add(time.Hour+time.Second))
+		assert.EqualError(t, deposit.Check(now, time.Hour), "invalid deposit: time is too far in the future")
+	})
+
+	t.Run("Check should leave amounts that cannot be parsed to the validator", func(t *testing.T) {
+		deposit := newDeposit("1", "1", "$1.2.3", now)
+		assert.NoError(t, deposit.Check(now, time.Hour))
+	})
+}
+
+func TestParseJsonStrict(t *testing.T) {
+	t.Run("ParseJsonStrict should parse deposits that follow the schema", func(t *testing.T) {
+		deposit, err := ParseJsonStrict(`{"id":"1","customer_id":"2","load_amount":"12.00","currency":"EUR","time":"2000-01-01T00:00:00Z"}`)
+		assert.NoError(t, err)
+		assert.Equal(t, &Deposit{ID: "1", CustomerID: "2", Amount: "12.00", Currency: "EUR", Time: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), ParsedAmount: 1200}, deposit)
+	})
+
+	t.Run("ParseJsonStrict should return an error for payloads ParseJson accepts", func(t *testing.T) {
+		cases := map[string]string{
+			`{"id":"1","customer_id":"1","load_amount":"$1.00","time":"2000-01-01T00:00:00Z","ParsedAmount":500000}`: `unknown field "ParsedAmount"`,
+			`{"id":"1","customer_id":"1","load_amount":"$1.00","time":"2000-01-01T00:00:00Z","note":""}`:             `unknown field "note"`,
+			`{"Id":"1","id":"1","customer_id":"1","load_amount":"$1.00","time":"2000-01-01T00:00:00Z"}`:              `unknown field "Id"`,
+			`{"id":"1","id":"2","customer_id":"1","load_amount":"$1.00","time":"2000-01-01T00:00:00Z"}`:              "id is given more than once",
+			`{"id":null,"customer_id":"1","load_amount":"$1.00","time":"2000-01-01T00:00:00Z"}`:                      "id cannot be null",
+			`{"id":"1","customer_id":"1","load_amount":"1.00","currency":"eur","time":"2000-01-01T00:00:00Z"}`:       `currency "eur" is not an upper case code`,
+			`{"id":"1","customer_id":"1","load_amount":"1.00","currency":"","time":"2000-01-01T00:00:00Z"}`:          `currency "" is not an upper case code`,
+		}
+
+		for input, expected := range cases {
+			_, err := ParseJson(input)
+			assert.NoError(t, err, input)
+
+			_, err = ParseJsonStrict(input)
+			assert.EqualError(t, err, expected, input)
+		}
+	})
+
+	t.Run("ParseJsonStrict should return an error for missing fields", func(t *testing.T) {
+		_, err := ParseJsonStrict(`{"id":"1","customer_id":"1","time":"2000-01-01T00:00:00Z"}`)
+		assert.EqualError(t, err, "missing load_amount")
+	})
+
+	t.Run("ParseJsonStrict should return an error for data after the deposit", func(t *testing.T) {
+		_, err := ParseJsonStrict(`{"id":"1","customer_id":"1","load_amount":"$1.00","time":"2000-01-01T00:00:00Z"} {}`)
+		assert.EqualError(t, err, "unexpected data after deposit")
+	})
+
+	t.Run("ParseJson should not read the parsed amount from the payload", func(t *testing.T) {
+		deposit, err := ParseJson(`{"id":"1","customer_id":"1","load_amount":"$1.00","time":"2000-01-01T00:00:00Z","ParsedAmount":500000}`)
+		assert.NoError(t, err)
+		assert.Equal(t, 1*Dollar, deposit.ParsedAmount)
 	})
 }
@@ -0,0 +1,468 @@
+package deposit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	snapshotFileName = "snapshot.json"
+	logFileName      = "wal.jsonl"
+
+	// The number of updates logged before the log is replaced by a snapshot
+	snapshotInterval = 10000
+)
+
+// DiskStore keeps a validator's state in memory and saves it in a directory so that it
+// survives the process exiting. Every update is appended to a write-ahead log and synced to
+// disk before it returns, so that a deposit that has been validated is never validated
+// again even if the machine crashes. The log is replaced by a snapshot of the whole state
+// every so often and when the store is closed.
+type DiskStore struct {
+	dir    string
+	memory *MemoryStore
+
+	// Guards the log. It is locked while holding the lock of a shard, or of every shard, so
+	// it must never be held while locking a shard.
+	mu       sync.Mutex
+	log      *os.File
+	sequence uint64
+	logged   int
+
+	// The error that stopped the log being written. The state in memory no longer matches
+	// the log after a failed write, so the store cannot be updated again.
+	err error
+}
+
+// A logRecord is a change to a customer's state written to the log. Deposits whose keys
+// leave out the customer are logged separately, as they are not part of its state.
+type logRecord struct {
+	Sequence        uint64             `json:"seq"`
+	CustomerID      string             `json:"customer_id"`
+	Validated       []validatedDeposit `json:"validated,omitempty"`
+	GlobalValidated []validatedDeposit `json:"global_validated,omitempty"`
+	Accounts        []accountSnapshot  `json:"accounts,omitempty"`
+	Forget          *forgetRecord      `json:"forget,omitempty"`
+}
+
+// A forgetRecord is logged when customers forget the deposits made before a time, with the
+// customers of a shard logged together. The customer of deposits whose keys leave out the
+// customer is empty.
+type forgetRecord struct {
+	Before    time.Time `json:"before"`
+	Customers []string  `json:"customers"`
+}
+
+// OpenDiskStore opens the store saved in the directory, creating it if necessary
+func OpenDiskStore(dir string) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &DiskStore{dir: dir, memory: NewMemoryStore()}
+
+	if err := s.loadSnapshot(); err != nil {
+		return nil, err
+	}
+
+	if err := s.replayLog(); err != nil {
+		return nil, err
+	}
+
+	log, err := os.OpenFile(filepath.Join(dir, logFileName), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s.log = log
+	return s, nil
+}
+
+// loadSnapshot reads the latest snapshot, if there is one
+func (s *DiskStore) loadSnapshot() error {
+	file, err := os.Open(filepath.Join(s.dir, snapshotFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	snap, err := readSnapshot(file)
+	if err != nil {
+		return err
+	}
+
+	for _, customer := range snap.Customers {
+		s.memory.restore(customer)
+	}
+
+	s.sequence = snap.Sequence
+	return nil
+}
+
+// replayLog applies the updates logged since the snapshot was taken. A final record that is
+// incomplete because the process stopped while writing it is removed from the log.
+func (s *DiskStore) replayLog() error {
+	path := filepath.Join(s.dir, logFileName)
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	offset := 0
+	for offset < len(data) {
+		end := bytes.IndexByte(data[offset:], '\n')
+
+		var record logRecord
+		if end < 0 || json.Unmarshal(data[offset:offset+end], &record) != nil {
+			if end < 0 || offset+end+1 == len(data) {
+				return os.Truncate(path, int64(offset))
+			}
+
+			return fmt.Errorf("invalid record in %s at offset %d", path, offset)
+		}
+
+		// Records from before the snapshot are already part of it
+		if record.Sequence > s.sequence {
+			if record.Forget != nil {
+				for _, customerID := range record.Forget.Customers {
+					s.memory.shardFor(customerID).forgetCustomer(customerID, record.Forget.Before)
+				}
+			} else {
+				s.memory.restore(customerSnapshot{CustomerID: record.CustomerID, Validated: record.Validated, Accounts: record.Accounts})
+			}
+			if len(record.GlobalValidated) > 0 {
+				s.memory.restore(customerSnapshot{Validated: record.GlobalValidated})
+			}
+			s.sequence = record.Sequence
+			s.logged++
+		}
+
+		offset += end + 1
+	}
+
+	return nil
+}
+
+// Update calls f with a transaction over the customer's state and logs the changes it
+// makes. Changes made before f returns an error cannot be undone in memory, so they are
+// logged too.
+func (s *DiskStore) Update(customerID string, f func(tx Tx) error) error {
+	err := s.memory.Update(customerID, func(memoryTx Tx) error {
+		if err := s.failed(); err != nil {
+			return err
+		}
+
+		tx := &diskTx{Tx: memoryTx, record: logRecord{CustomerID: customerID}, accounts: make(map[string]*Account)}
+		err := f(tx)
+
+		if logErr := s.append(tx); logErr != nil {
+			return logErr
+		}
+
+		return err
+	})
+
+	if err != nil {
+		return err
+	}
+
+	return s.snapshotIfDue()
+}
+
+func (s *DiskStore) View(customerID string, f func(tx Tx) error) error {
+	return s.memory.View(customerID, f)
+}
+
+// ForgetValidated forgets the deposits made before the time one shard at a time, logging
+// the customers of each shard that forgot any so that they stay forgotten when the store is
+// reopened
+func (s *DiskStore) ForgetValidated(before time.Time) (int, error) {
+	forgotten := 0
+
+	for _, shard := range s.memory.allShards() {
+		shard.mu.Lock()
+		n, err := s.forget(shard, before)
+		shard.mu.Unlock()
+
+		forgotten += n
+		if err != nil {
+			return forgotten, err
+		}
+	}
+
+	return forgotten, nil
+}
+
+// forget forgets the deposits in the shard made before the time and logs the customers that
+// forgot any. The caller must hold the shard's lock.
+func (s *DiskStore) forget(sh *shard, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return 0, s.err
+	}
+
+	forgotten := 0
+	record := logRecord{Forget: &forgetRecord{Before: before}}
+
+	for customerID := range sh.customers {
+		if n := sh.forgetCustomer(customerID, before); n > 0 {
+			forgotten += n
+			record.Forget.Customers = append(record.Forget.Customers, customerID)
+		}
+	}
+
+	if forgotten == 0 {
+		return 0, nil
+	}
+
+	return forgotten, s.write(record)
+}
+
+func (s *DiskStore) CountValidated() (int, error) {
+	return s.memory.CountValidated()
+}
+
+// Close takes a snapshot so the next time the store is opened it does not have to replay
+// the log
+func (s *DiskStore) Close() error {
+	err := s.Snapshot()
+
+	if closeErr := s.log.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// Snapshot saves the whole state to the snapshot file and empties the log
+func (s *DiskStore) Snapshot() error {
+	s.memory.lockAll()
+	defer s.memory.unlockAll()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+
+	return s.save()
+}
+
+// WriteSnapshot writes the state of every customer to w
+func (s *DiskStore) WriteSnapshot(w io.Writer) error {
+	return s.memory.WriteSnapshot(w)
+}
+
+// ReadSnapshot replaces the state of every customer with the snapshot read from r, and saves
+// it to the snapshot file
+func (s *DiskStore) ReadSnapshot(r io.Reader) error {
+	snap, err := readSnapshot(r)
+	if err != nil {
+		return err
+	}
+
+	s.memory.lockAll()
+	defer s.memory.unlockAll()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+
+	s.memory.replace(snap)
+
+	// If the restored state cannot be saved the files still hold the old state, so the store
+	// fails rather than log updates on top of it
+	if err := s.save(); err != nil {
+		s.err = err
+		return err
+	}
+
+	return nil
+}
+
+// save writes the state to the snapshot file and empties the log. The caller must hold the
+// lock of every shard and of the log.
+func (s *DiskStore) save() error {
+	snap := s.memory.snapshot()
+	snap.Sequence = s.sequence
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileSync(filepath.Join(s.dir, snapshotFileName), data); err != nil {
+		return err
+	}
+
+	// The records in the log are skipped once they are in the snapshot, so the store can
+	// still be opened if this fails
+	if err := s.log.Truncate(0); err != nil {
+		return err
+	}
+
+	s.logged = 0
+	return s.log.Sync()
+}
+
+// snapshotIfDue takes a snapshot once enough updates have been logged
+func (s *DiskStore) snapshotIfDue() error {
+	s.mu.Lock()
+	due := s.logged >= snapshotInterval
+	s.mu.Unlock()
+
+	if !due {
+		return nil
+	}
+
+	return s.Snapshot()
+}
+
+func (s *DiskStore) failed() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.err
+}
+
+// append writes the changes made in a transaction to the log
+func (s *DiskStore) append(tx *diskTx) error {
+	if len(tx.record.Validated) == 0 && len(tx.record.GlobalValidated) == 0 && len(tx.accounts) == 0 {
+		return nil
+	}
+
+	for _, account := range tx.accounts {
+		tx.record.Accounts = append(tx.record.Accounts, newAccountSnapshot(account))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return s.err
+	}
+
+	return s.write(tx.record)
+}
+
+// write appends the record to the log and syncs it to disk. The caller must hold the lock
+// of the log.
+func (s *DiskStore) write(record logRecord) error {
+	record.Sequence = s.sequence + 1
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.log.Write(append(data, '\n')); err != nil {
+		s.err = fmt.Errorf("writing to the log failed: %w", err)
+		return s.err
+	}
+
+	if err := s.log.Sync(); err != nil {
+		s.err = fmt.Errorf("syncing the log failed: %w", err)
+		return s.err
+	}
+
+	s.sequence++
+	s.logged++
+	return nil
+}
+
+// A diskTx records the changes made in a transaction over the state in memory
+type diskTx struct {
+	Tx
+	record   logRecord
+	accounts map[string]*Account
+}
+
+func (tx *diskTx) MarkValidated(key DedupeKey, at time.Time) (bool, error) {
+	marked, err := tx.Tx.MarkValidated(key, at)
+	if !marked || err != nil {
+		return marked, err
+	}
+
+	if key.CustomerID == "" {
+		tx.record.GlobalValidated = append(tx.record.GlobalValidated, validatedDeposit{ID: key.DepositID, Time: at})
+	} else {
+		tx.record.Validated = append(tx.record.Validated, validatedDeposit{ID: key.DepositID, Time: at})
+	}
+
+	return true, nil
+}
+
+// SaveDecision logs the decision with the deposit marked in the transaction. Decisions for
+// deposits marked in earlier transactions are only kept in memory.
+func (tx *diskTx) SaveDecision(key DedupeKey, decision Decision) error {
+	if err := tx.Tx.SaveDecision(key, decision); err != nil {
+		return err
+	}
+
+	logged := tx.record.Validated
+	if key.CustomerID == "" {
+		logged = tx.record.GlobalValidated
+	}
+
+	for i := range logged {
+		if logged[i].ID == key.DepositID {
+			logged[i].Decision = &decision
+		}
+	}
+
+	return nil
+}
+
+func (tx *diskTx) SaveAccount(account *Account) error {
+	if err := tx.Tx.SaveAccount(account); err != nil {
+		return err
+	}
+
+	tx.accounts[account.Currency] = account
+	return nil
+}
+
+// writeFileSync replaces the file with the data, so that it holds either the old or the
+// new data if the process stops part way through
+func writeFileSync(path string, data []byte) error {
+	tmp := path + ".tmp"
+
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
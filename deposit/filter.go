@@ -0,0 +1,160 @@
+package deposit
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+	"time"
+)
+
+// NewMemoryStoreWithFilter creates an in-memory store that remembers validated deposits in
+// bloom filters rather than by ID, so that the memory used for each day of deposits is fixed
+// no matter how long the IDs are. A filter is sized for each day of deposit times, and
+// holding the expected number of deposits per day it reports an unseen deposit as validated
+// at the given false-positive rate. Every day's filter is checked for a deposit, so over a
+// window of d days the rate is up to d times as high, and it rises further on days with
+// more deposits than expected.
+func NewMemoryStoreWithFilter(depositsPerDay int, falsePositiveRate float64) (*MemoryStore, error) {
+	if depositsPerDay <= 0 {
+		return nil, errors.New("the expected deposits per day must be positive")
+	}
+
+	if falsePositiveRate <= 0 || falsePositiveRate >= 1 {
+		return nil, errors.New("the false-positive rate must be between 0 and 1")
+	}
+
+	// Customers are spread evenly across the shards, each of which has its own filters.
+	// Deposits whose IDs are unique across customers all go in the global shard's filters.
+	s := NewMemoryStore()
+	for i := range s.shards {
+		s.shards[i].filter = newDedupeFilter(float64(depositsPerDay)/shardCount+1, falsePositiveRate)
+	}
+	s.global.filter = newDedupeFilter(float64(depositsPerDay), falsePositiveRate)
+
+	return s, nil
+}
+
+// newDedupeFilter creates a filter sized for n deposits a day at the false-positive rate
+func newDedupeFilter(n float64, falsePositiveRate float64) *dedupeFilter {
+	bits := math.Ceil(-n * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	hashes := int(math.Max(1, math.Round(bits/n*math.Ln2)))
+
+	return &dedupeFilter{bits: uint64(bits), hashes: hashes, days: make(map[int64]*bloomFilter)}
+}
+
+// Deposits are added to the filter of the day they were made, counted from the Unix epoch
+const secondsPerDay = 24 * 60 * 60
+
+var errFilterSnapshot = errors.New("deposits remembered by a filter cannot be saved in a snapshot")
+
+// filtered reports whether the store remembers validated deposits with filters
+func (s *MemoryStore) filtered() bool {
+	return s.shards[0].filter != nil
+}
+
+// A dedupeFilter remembers validated deposits in a bloom filter for each day of deposit
+// times, so that days can be forgotten as a whole
+type dedupeFilter struct {
+	// The size of each day's filter, and the number of bits set for each deposit
+	bits   uint64
+	hashes int
+
+	// The filters by the number of days since the Unix epoch
+	days map[int64]*bloomFilter
+}
+
+type bloomFilter struct {
+	words []uint64
+
+	// The number of deposits added to the filter
+	count int
+}
+
+func (f *dedupeFilter) add(key DedupeKey, at time.Time) {
+	d := at.Unix() / secondsPerDay
+	if at.Unix()%secondsPerDay < 0 {
+		d--
+	}
+
+	filter, ok := f.days[d]
+	if !ok {
+		filter = &bloomFilter{words: make([]uint64, (f.bits+63)/64)}
+		f.days[d] = filter
+	}
+
+	start, step := f.probe(key)
+	for i := 0; i < f.hashes; i++ {
+		bit := (start + uint64(i)*step) % f.bits
+		filter.words[bit/64] |= 1 << (bit % 64)
+	}
+
+	filter.count++
+}
+
+func (f *dedupeFilter) contains(key DedupeKey) bool {
+	start, step := f.probe(key)
+
+	for _, filter := range f.days {
+		if filter.contains(start, step, f) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b *bloomFilter) contains(start uint64, step uint64, f *dedupeFilter) bool {
+	for i := 0; i < f.hashes; i++ {
+		bit := (start + uint64(i)*step) % f.bits
+		if b.words[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// forget drops the filters of days that end before the time, returning the number of
+// deposits they held and the last moment of the latest day dropped
+func (f *dedupeFilter) forget(before time.Time) (int, time.Time) {
+	forgotten := 0
+	var latest time.Time
+
+	for d, filter := range f.days {
+		end := time.Unix((d+1)*secondsPerDay, 0)
+		if end.After(before) {
+			continue
+		}
+
+		forgotten += filter.count
+		delete(f.days, d)
+
+		if end.After(latest) {
+			latest = end
+		}
+	}
+
+	if latest.IsZero() {
+		return forgotten, latest
+	}
+
+	return forgotten, latest.Add(-time.Nanosecond)
+}
+
+// probe returns the first bit of a deposit and the distance between its bits, which are
+// derived from two hashes of the deposit's key. The key's encoding is hashed rather than its
+// IDs, so that IDs which run together the same way differ.
+func (f *dedupeFilter) probe(key DedupeKey) (uint64, uint64) {
+	h := fnv.New128a()
+	h.Write([]byte(key.String()))
+	sum := h.Sum(nil)
+
+	// A step of zero would set the same bit every time
+	step := binary.BigEndian.Uint64(sum[8:]) % f.bits
+	if step == 0 {
+		step = 1
+	}
+
+	return binary.BigEndian.Uint64(sum[:8]) % f.bits, step
+}
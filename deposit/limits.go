@@ -0,0 +1,100 @@
+package deposit
+
+import (
+	"errors"
+	"time"
+)
+
+// Limits are the velocity limits applied to a customer's deposits in a single currency.
+// The daily and weekly limits are required, while zero monthly limits are not enforced.
+type Limits struct {
+	DailyAmount      Money `yaml:"daily_amount"`
+	WeeklyAmount     Money `yaml:"weekly_amount"`
+	MaxDailyDeposits int   `yaml:"max_daily_deposits"`
+
+	MonthlyAmount      Money `yaml:"monthly_amount"`
+	MaxMonthlyDeposits int   `yaml:"max_monthly_deposits"`
+
+	// Limits over windows that end at the time of each deposit, rather than calendar
+	// periods that reset at midnight
+	Rolling []RollingLimit `yaml:"rolling"`
+}
+
+// A RollingLimit limits the deposits made in any window of the given length, e.g. any
+// 24 hours. Zero limits are not enforced.
+type RollingLimit struct {
+	Window      time.Duration `yaml:"window"`
+	Amount      Money         `yaml:"amount"`
+	MaxDeposits int           `yaml:"max_deposits"`
+}
+
+func (l Limits) validate() error {
+	if l.DailyAmount <= 0 || l.WeeklyAmount <= 0 {
+		return errors.New("amounts must be positive")
+	}
+
+	if l.MaxDailyDeposits <= 0 {
+		return errors.New("max daily deposits must be positive")
+	}
+
+	if l.DailyAmount > l.WeeklyAmount {
+		return errors.New("daily amount cannot exceed the weekly amount")
+	}
+
+	if l.MonthlyAmount < 0 || l.MaxMonthlyDeposits < 0 {
+		return errors.New("monthly limits cannot be negative")
+	}
+
+	for _, rolling := range l.Rolling {
+		if rolling.Window <= 0 {
+			return errors.New("rolling windows must be positive")
+		}
+
+		if rolling.Amount < 0 || rolling.MaxDeposits < 0 {
+			return errors.New("rolling limits cannot be negative")
+		}
+	}
+
+	return nil
+}
+
+// merge returns the limits with any non-zero values in override taking precedence
+func (l Limits) merge(override Limits) Limits {
+	if override.DailyAmount != 0 {
+		l.DailyAmount = override.DailyAmount
+	}
+
+	if override.WeeklyAmount != 0 {
+		l.WeeklyAmount = override.WeeklyAmount
+	}
+
+	if override.MaxDailyDeposits != 0 {
+		l.MaxDailyDeposits = override.MaxDailyDeposits
+	}
+
+	if override.MonthlyAmount != 0 {
+		l.MonthlyAmount = override.MonthlyAmount
+	}
+
+	if override.MaxMonthlyDeposits != 0 {
+		l.MaxMonthlyDeposits = override.MaxMonthlyDeposits
+	}
+
+	if len(override.Rolling) != 0 {
+		l.Rolling = override.Rolling
+	}
+
+	return l
+}
+
+// longestWindow returns the length of the longest rolling window
+func (l Limits) longestWindow() time.Duration {
+	var longest time.Duration
+	for _, rolling := range l.Rolling {
+		if rolling.Window > longest {
+			longest = rolling.Window
+		}
+	}
+
+	return longest
+}
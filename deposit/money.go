@@ -0,0 +1,81 @@
+package deposit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Money is an exact amount of currency stored in minor units (cents). Since it is an
+// integer, amounts can be added and compared with the usual operators without the
+// rounding drift of floating point arithmetic.
+type Money int64
+
+// Common units of Money
+const (
+	Cent   Money = 1
+	Dollar Money = 100
+)
+
+// The number of decimal places of Money, which is the most a currency can have
+const moneyDecimals = 2
+
+// parseMoney parses an amount in the default currency such as "5000" or "$5,000.00". The
+// dollar sign is optional, and otherwise amounts follow the grammar of ParseAmount.
+func parseMoney(s string) (Money, error) {
+	money, err := parseNumber(strings.TrimPrefix(s, "$"), EnglishLocale, currencies[DefaultCurrency].MinorUnits)
+	if err != nil {
+		return 0, &AmountError{Amount: s, Err: err}
+	}
+
+	return money, nil
+}
+
+// String formats the amount the same way it appears in a deposit, e.g. "$123.45"
+func (m Money) String() string {
+	sign := ""
+	if m < 0 {
+		sign = "-"
+		m = -m
+	}
+
+	return fmt.Sprintf("%s$%d.%02d", sign, m/Dollar, m%Dollar)
+}
+
+// MarshalText formats the amount so that it can be used in text based formats like YAML
+func (m Money) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalText parses an amount such as "5000" or "$5,000.00" from text based formats
+func (m *Money) UnmarshalText(text []byte) error {
+	money, err := parseMoney(string(text))
+	if err != nil {
+		return err
+	}
+
+	*m = money
+	return nil
+}
+
+// MarshalJSON encodes the amount as a formatted string so that no precision is lost
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+// UnmarshalJSON decodes an amount formatted as a string, e.g. "$123.45"
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New("amount must be a string")
+	}
+
+	money, err := parseMoney(s)
+	if err != nil {
+		return err
+	}
+
+	*m = money
+	return nil
+}
@@ -0,0 +1,77 @@
+package deposit
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMoney_UnmarshalText(t *testing.T) {
+	t.Run("UnmarshalText should parse amounts into cents", func(t *testing.T) {
+		cases := map[string]Money{
+			"$3318.47":  331847,
+			"$0.01":     1,
+			"$12.5":     1250,
+			"12":        1200,
+			"$5,000.00": 500000,
+		}
+
+		for input, expected := range cases {
+			var money Money
+			assert.NoError(t, money.UnmarshalText([]byte(input)), input)
+			assert.Equal(t, expected, money, input)
+		}
+	})
+
+	t.Run("UnmarshalText should reject amounts outside the grammar of ParseAmount", func(t *testing.T) {
+		for _, input := range []string{"", "$", "%5000.00", "$1.001", "$.50", "$12.", "$-1.00", "-1.00", "+1.00", "$1e4", "$NaN", "$01.00", "€1.00", "$99999999999999999999"} {
+			var money Money
+			err := money.UnmarshalText([]byte(input))
+
+			var amountErr *AmountError
+			assert.True(t, errors.As(err, &amountErr), input)
+		}
+	})
+}
+
+func TestMoney_String(t *testing.T) {
+	t.Run("String should format the amount like a deposit", func(t *testing.T) {
+		assert.Equal(t, "$3318.47", Money(331847).String())
+		assert.Equal(t, "$0.05", Money(5).String())
+		assert.Equal(t, "-$1.50", Money(-150).String())
+	})
+}
+
+func TestMoney_JSON(t *testing.T) {
+	t.Run("Money should round trip through JSON", func(t *testing.T) {
+		data, err := json.Marshal(Money(500000))
+		assert.NoError(t, err)
+		assert.Equal(t, `"$5000.00"`, string(data))
+
+		var money Money
+		assert.NoError(t, json.Unmarshal(data, &money))
+		assert.Equal(t, 5000*Dollar, money)
+	})
+
+	t.Run("UnmarshalJSON should return an error if the amount is not a string", func(t *testing.T) {
+		var money Money
+		assert.Error(t, json.Unmarshal([]byte(`5000`), &money))
+	})
+}
+
+func TestValidate_ExactDailyLimit(t *testing.T) {
+	tearDownTestCase := setupTestCase(t)
+	defer tearDownTestCase(t)
+
+	t.Run("Validate should accept deposits that reach the daily limit exactly", func(t *testing.T) {
+		first := newDeposit("1", "5", "$4999.90", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC))
+		second := newDeposit("2", "5", "$0.07", time.Date(2021, 1, 9, 11, 0, 0, 0, time.UTC))
+		third := newDeposit("3", "5", "$0.03", time.Date(2021, 1, 9, 12, 0, 0, 0, time.UTC))
+		assert.True(t, v.Validate(&first).Accepted)
+		assert.True(t, v.Validate(&second).Accepted)
+		assert.True(t, v.Validate(&third).Accepted)
+	})
+}
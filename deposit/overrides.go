@@ -0,0 +1,126 @@
+package deposit
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Overrides assign customers to tiers and adjust the limits of individual customers
+type Overrides struct {
+	Customers map[string]CustomerOverride `yaml:"customers"`
+}
+
+// A CustomerOverride changes the limits applied to a single customer. The customer gets
+// the limits of their tier, and any non-zero limits given here take precedence over both
+// the tier and the policy. The time zone and week start, if given, replace the policy's
+// for the customer's ledgers.
+type CustomerOverride struct {
+	Tier      string            `yaml:"tier"`
+	Limits    map[string]Limits `yaml:"limits"`
+	TimeZone  string            `yaml:"time_zone"`
+	WeekStart string            `yaml:"week_start"`
+}
+
+// limitsFor resolves the limits for a customer's deposits in a currency, reporting false
+// if the currency is not accepted
+func (p Policy) limitsFor(overrides Overrides, customerID string, currency string) (Limits, bool) {
+	limits, ok := p.Currencies[currency]
+	if !ok {
+		return Limits{}, false
+	}
+
+	override := overrides.Customers[customerID]
+	if override.Tier != "" {
+		limits = limits.merge(p.Tiers[override.Tier][currency])
+	}
+
+	return limits.merge(override.Limits[currency]), true
+}
+
+// calendars returns the calendar of the policy and of each customer that overrides it
+func (p Policy) calendars(overrides Overrides) (calendar, map[string]calendar, error) {
+	policyCalendar, err := newCalendar(utcCalendar, p.TimeZone, p.WeekStart)
+	if err != nil {
+		return calendar{}, nil, err
+	}
+
+	customerCalendars := make(map[string]calendar)
+
+	for customerID, override := range overrides.Customers {
+		if override.TimeZone == "" && override.WeekStart == "" {
+			continue
+		}
+
+		c, err := newCalendar(policyCalendar, override.TimeZone, override.WeekStart)
+		if err != nil {
+			return calendar{}, nil, fmt.Errorf("customer %s has %w", customerID, err)
+		}
+
+		customerCalendars[customerID] = c
+	}
+
+	return policyCalendar, customerCalendars, nil
+}
+
+// validateOverrides returns an error if the overrides refer to unknown tiers or currencies,
+// or result in limits or calendars that cannot be used
+func (p Policy) validateOverrides(overrides Overrides) error {
+	if _, _, err := p.calendars(overrides); err != nil {
+		return err
+	}
+
+	for customerID, override := range overrides.Customers {
+		if _, ok := p.Tiers[override.Tier]; override.Tier != "" && !ok {
+			return fmt.Errorf("customer %s has unknown tier %q", customerID, override.Tier)
+		}
+
+		for currency := range override.Limits {
+			if _, ok := p.Currencies[currency]; !ok {
+				return fmt.Errorf("customer %s has limits for unaccepted currency %q", customerID, currency)
+			}
+		}
+
+		for currency := range p.Currencies {
+			limits, _ := p.limitsFor(overrides, customerID, currency)
+			if err := limits.validate(); err != nil {
+				return fmt.Errorf("invalid %s limits for customer %s: %w", currency, customerID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ParseOverrides reads YAML or JSON customer overrides
+func ParseOverrides(r io.Reader) (Overrides, error) {
+	var overrides Overrides
+
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(&overrides); err != nil && err != io.EOF {
+		return Overrides{}, fmt.Errorf("invalid overrides: %w", err)
+	}
+
+	for customerID, override := range overrides.Customers {
+		override.Limits = upperCaseKeys(override.Limits)
+		overrides.Customers[customerID] = override
+	}
+
+	return overrides, nil
+}
+
+// LoadOverrides reads customer overrides from a file. They are validated against the
+// policy when given to a validator.
+func LoadOverrides(path string) (Overrides, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Overrides{}, err
+	}
+	defer file.Close()
+
+	return ParseOverrides(file)
+}
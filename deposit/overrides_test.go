@@ -0,0 +1,129 @@
+package deposit
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tieredPolicy() Policy {
+	policy := DefaultPolicy()
+	policy.Tiers = map[string]map[string]Limits{
+		"premium": {"USD": {DailyAmount: 10000 * Dollar, WeeklyAmount: 40000 * Dollar}},
+		"flagged": {"USD": {DailyAmount: 100 * Dollar, MaxDailyDeposits: 1}},
+	}
+
+	return policy
+}
+
+func TestPolicy_LimitsFor(t *testing.T) {
+	policy := tieredPolicy()
+	overrides := Overrides{Customers: map[string]CustomerOverride{
+		"1": {Tier: "premium"},
+		"2": {Tier: "premium", Limits: map[string]Limits{"USD": {MaxDailyDeposits: 5}}},
+		"3": {Limits: map[string]Limits{"EUR": {WeeklyAmount: 6000 * Dollar}}},
+	}}
+
+	t.Run("limitsFor should use the policy limits for customers without overrides", func(t *testing.T) {
+		limits, ok := policy.limitsFor(overrides, "4", "USD")
+		assert.True(t, ok)
+		assert.Equal(t, policy.Currencies["USD"], limits)
+	})
+
+	t.Run("limitsFor should apply the customer's tier", func(t *testing.T) {
+		limits, _ := policy.limitsFor(overrides, "1", "USD")
+		assert.Equal(t, Limits{DailyAmount: 10000 * Dollar, WeeklyAmount: 40000 * Dollar, MaxDailyDeposits: 3}, limits)
+
+		limits, _ = policy.limitsFor(overrides, "1", "EUR")
+		assert.Equal(t, policy.Currencies["EUR"], limits)
+	})
+
+	t.Run("limitsFor should give customer limits precedence over the tier", func(t *testing.T) {
+		limits, _ := policy.limitsFor(overrides, "2", "USD")
+		assert.Equal(t, Limits{DailyAmount: 10000 * Dollar, WeeklyAmount: 40000 * Dollar, MaxDailyDeposits: 5}, limits)
+
+		limits, _ = policy.limitsFor(overrides, "3", "EUR")
+		assert.Equal(t, Limits{DailyAmount: 5000 * Dollar, WeeklyAmount: 6000 * Dollar, MaxDailyDeposits: 3}, limits)
+	})
+
+	t.Run("limitsFor should report unaccepted currencies", func(t *testing.T) {
+		_, ok := policy.limitsFor(overrides, "1", "JPY")
+		assert.False(t, ok)
+	})
+}
+
+func TestPolicy_ValidateTiers(t *testing.T) {
+	t.Run("Validate should return an error for invalid tier limits", func(t *testing.T) {
+		policy := DefaultPolicy()
+		policy.Tiers = map[string]map[string]Limits{"premium": {"USD": {DailyAmount: 50000 * Dollar}}}
+		assert.Error(t, policy.Validate())
+
+		policy.Tiers = map[string]map[string]Limits{"premium": {"JPY": {DailyAmount: 50 * Dollar}}}
+		assert.Error(t, policy.Validate())
+	})
+
+	t.Run("Validate should return an error for overrides with unknown tiers", func(t *testing.T) {
+		policy := tieredPolicy()
+		policy.Overrides = Overrides{Customers: map[string]CustomerOverride{"1": {Tier: "gold"}}}
+		assert.Error(t, policy.Validate())
+	})
+}
+
+func TestValidate_Overrides(t *testing.T) {
+	v := NewValidatorWithPolicy(tieredPolicy())
+	assert.NoError(t, v.SetOverrides(Overrides{Customers: map[string]CustomerOverride{
+		"1": {Tier: "premium"},
+		"2": {Tier: "flagged"},
+	}}))
+
+	t.Run("Validate should apply higher limits to premium customers", func(t *testing.T) {
+		deposit := newDeposit("1", "1", "$8000.00", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC))
+		assert.True(t, v.Validate(&deposit).Accepted)
+	})
+
+	t.Run("Validate should apply lower limits to flagged customers", func(t *testing.T) {
+		first := newDeposit("1", "2", "$50.00", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC))
+		second := newDeposit("2", "2", "$1.00", time.Date(2021, 1, 9, 11, 0, 0, 0, time.UTC))
+		assert.True(t, v.Validate(&first).Accepted)
+		assert.False(t, v.Validate(&second).Accepted)
+	})
+
+	t.Run("SetOverrides should replace the overrides", func(t *testing.T) {
+		assert.NoError(t, v.SetOverrides(Overrides{}))
+
+		deposit := newDeposit("2", "1", "$8000.00", time.Date(2021, 1, 10, 10, 0, 0, 0, time.UTC))
+		assert.False(t, v.Validate(&deposit).Accepted)
+	})
+
+	t.Run("SetOverrides should return an error for invalid overrides", func(t *testing.T) {
+		err := v.SetOverrides(Overrides{Customers: map[string]CustomerOverride{"1": {Tier: "gold"}}})
+		assert.EqualError(t, err, `customer 1 has unknown tier "gold"`)
+	})
+}
+
+func TestParseOverrides(t *testing.T) {
+	t.Run("ParseOverrides should read YAML overrides", func(t *testing.T) {
+		overrides, err := ParseOverrides(strings.NewReader(`
+customers:
+  "528":
+    tier: premium
+    limits:
+      usd: {max_daily_deposits: 4}
+`))
+		assert.NoError(t, err)
+		assert.Equal(t, CustomerOverride{Tier: "premium", Limits: map[string]Limits{"USD": {MaxDailyDeposits: 4}}}, overrides.Customers["528"])
+	})
+
+	t.Run("ParseOverrides should accept an empty file", func(t *testing.T) {
+		overrides, err := ParseOverrides(strings.NewReader(""))
+		assert.NoError(t, err)
+		assert.Empty(t, overrides.Customers)
+	})
+
+	t.Run("ParseOverrides should return an error for unknown fields", func(t *testing.T) {
+		_, err := ParseOverrides(strings.NewReader("customers:\n  \"1\":\n    level: premium\n"))
+		assert.Error(t, err)
+	})
+}
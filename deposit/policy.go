@@ -0,0 +1,249 @@
+package deposit
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// A Policy configures the velocity limits enforced by a validator
+type Policy struct {
+	// The limits for each currency that deposits are accepted in
+	Currencies map[string]Limits `yaml:"currencies"`
+
+	// If set, deposits are converted into the base currency and its limits are also
+	// applied to the customer's combined deposits across all currencies
+	BaseCurrency string `yaml:"base_currency"`
+
+	// A CSV file of exchange rates, relative to the policy file, loaded into Rates
+	RatesFile string     `yaml:"rates_file"`
+	Rates     RateSource `yaml:"-"`
+
+	// The limits for customers in each tier, by currency. Zero limits are inherited
+	// from the currency's limits above.
+	Tiers map[string]map[string]Limits `yaml:"tiers"`
+
+	// A file of customer overrides, relative to the policy file, loaded into Overrides
+	OverridesFile string    `yaml:"overrides_file"`
+	Overrides     Overrides `yaml:"-"`
+
+	// The names of the rules to run, in order. DefaultRules are run if none are given.
+	Rules []string `yaml:"rules"`
+
+	// The IANA time zone in which days and weeks begin, and the day weeks start on.
+	// Days end at midnight UTC and weeks start on Monday by default.
+	TimeZone  string `yaml:"time_zone"`
+	WeekStart string `yaml:"week_start"`
+
+	// How far a deposit can be behind the latest deposit into the same account and still
+	// be validated. Later deposits are rejected since their ledgers have been forgotten.
+	Lateness time.Duration `yaml:"lateness"`
+
+	// How long validated deposits are remembered in order to detect duplicates, measured
+	// back from the latest deposit validated. Deposits made before the window are rejected
+	// as too late, since they cannot be told apart from duplicates that were forgotten.
+	// Deposits are remembered forever if this is zero.
+	DedupeWindow time.Duration `yaml:"dedupe_window"`
+
+	// Whether load IDs are unique for each customer, which is the default, or across every
+	// customer. Deposits remembered in one scope are not found after changing to the other.
+	DedupeScope DedupeScope `yaml:"dedupe_scope"`
+
+	// How far a deposit's time can be ahead of the clock, allowing for the clocks of the
+	// systems sending deposits to differ. Deposits further in the future are invalid.
+	ClockSkew time.Duration `yaml:"clock_skew"`
+}
+
+// DefaultLateness is used by policies that do not set a lateness
+const DefaultLateness = 24 * time.Hour
+
+// DefaultClockSkew is used by policies that do not set a clock skew
+const DefaultClockSkew = time.Hour
+
+// DefaultPolicy returns the policy used when no policy file is given
+func DefaultPolicy() Policy {
+	return Policy{
+		Currencies: map[string]Limits{
+			"USD": {DailyAmount: 5000 * Dollar, WeeklyAmount: 20000 * Dollar, MaxDailyDeposits: 3},
+			"EUR": {DailyAmount: 5000 * Dollar, WeeklyAmount: 20000 * Dollar, MaxDailyDeposits: 3},
+			"GBP": {DailyAmount: 5000 * Dollar, WeeklyAmount: 20000 * Dollar, MaxDailyDeposits: 3},
+		},
+		Lateness:  DefaultLateness,
+		ClockSkew: DefaultClockSkew,
+	}
+}
+
+// Validate returns an error if the policy cannot be enforced
+func (p Policy) Validate() error {
+	if len(p.Currencies) == 0 {
+		return errors.New("policy must set limits for at least one currency")
+	}
+
+	for code, limits := range p.Currencies {
+		if currency, err := LookupCurrency(code); err != nil {
+			return err
+		} else if currency.Code != code {
+			return fmt.Errorf("currency %q must be an upper case ISO 4217 code", code)
+		}
+
+		if err := limits.validate(); err != nil {
+			return fmt.Errorf("invalid %s limits: %w", code, err)
+		}
+	}
+
+	for tier, currencies := range p.Tiers {
+		for code, limits := range currencies {
+			if _, ok := p.Currencies[code]; !ok {
+				return fmt.Errorf("tier %s has limits for unaccepted currency %q", tier, code)
+			}
+
+			if err := p.Currencies[code].merge(limits).validate(); err != nil {
+				return fmt.Errorf("invalid %s limits for tier %s: %w", code, tier, err)
+			}
+		}
+	}
+
+	if err := p.validateOverrides(p.Overrides); err != nil {
+		return err
+	}
+
+	if _, err := lookupRules(p.ruleNames()); err != nil {
+		return err
+	}
+
+	if p.Lateness < 0 {
+		return errors.New("lateness cannot be negative")
+	}
+
+	if p.ClockSkew < 0 {
+		return errors.New("clock skew cannot be negative")
+	}
+
+	if p.DedupeWindow < 0 {
+		return errors.New("dedupe window cannot be negative")
+	}
+
+	if p.DedupeWindow > 0 && p.DedupeWindow < p.Lateness {
+		return errors.New("dedupe window cannot be shorter than the lateness")
+	}
+
+	if p.DedupeScope != "" && p.DedupeScope != CustomerScope && p.DedupeScope != GlobalScope {
+		return fmt.Errorf("unknown dedupe scope %q", p.DedupeScope)
+	}
+
+	if p.BaseCurrency != "" {
+		if _, ok := p.Currencies[p.BaseCurrency]; !ok {
+			return fmt.Errorf("base currency %s has no limits", p.BaseCurrency)
+		}
+
+		if p.Rates == nil {
+			return fmt.Errorf("base currency %s requires exchange rates", p.BaseCurrency)
+		}
+	}
+
+	return nil
+}
+
+// ruleNames returns the names of the rules to run
+func (p Policy) ruleNames() []string {
+	if len(p.Rules) == 0 {
+		return DefaultRules
+	}
+
+	return p.Rules
+}
+
+// ParsePolicy reads a YAML or JSON policy. The policy is not validated and any rates
+// file it names is not loaded.
+func ParsePolicy(r io.Reader) (Policy, error) {
+	policy := Policy{Lateness: DefaultLateness, ClockSkew: DefaultClockSkew}
+
+	// YAML is a superset of JSON so a single decoder reads both formats
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(&policy); err != nil {
+		return Policy{}, fmt.Errorf("invalid policy: %w", err)
+	}
+
+	// Accept currency codes in any case
+	policy.Currencies = upperCaseKeys(policy.Currencies)
+	policy.BaseCurrency = strings.ToUpper(policy.BaseCurrency)
+
+	for tier, limits := range policy.Tiers {
+		policy.Tiers[tier] = upperCaseKeys(limits)
+	}
+
+	return policy, nil
+}
+
+// upperCaseKeys returns a copy of limits keyed by upper case currency codes
+func upperCaseKeys(limits map[string]Limits) map[string]Limits {
+	if limits == nil {
+		return nil
+	}
+
+	upper := make(map[string]Limits, len(limits))
+	for code, l := range limits {
+		upper[strings.ToUpper(code)] = l
+	}
+
+	return upper
+}
+
+// LoadPolicy reads and validates a policy file, along with its exchange rates
+func LoadPolicy(path string) (Policy, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Policy{}, err
+	}
+	defer file.Close()
+
+	policy, err := ParsePolicy(file)
+	if err != nil {
+		return Policy{}, err
+	}
+
+	if policy.RatesFile != "" {
+		if policy.Rates, err = LoadRatesFile(relativeTo(path, policy.RatesFile)); err != nil {
+			return Policy{}, err
+		}
+	}
+
+	if policy.OverridesFile != "" {
+		if policy.Overrides, err = LoadOverrides(policy.OverridesPath(path)); err != nil {
+			return Policy{}, err
+		}
+	}
+
+	if err := policy.Validate(); err != nil {
+		return Policy{}, err
+	}
+
+	return policy, nil
+}
+
+// OverridesPath returns the path of the overrides file of the policy read from policyFile,
+// or "" if it has none
+func (p Policy) OverridesPath(policyFile string) string {
+	if p.OverridesFile == "" {
+		return ""
+	}
+
+	return relativeTo(policyFile, p.OverridesFile)
+}
+
+// relativeTo resolves a path found in the policy file relative to the policy's directory
+func relativeTo(policyFile string, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(filepath.Dir(policyFile), path)
+}
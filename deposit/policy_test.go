@@ -0,0 +1,135 @@
+package deposit
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePolicy(t *testing.T) {
+	t.Run("ParsePolicy should read YAML policies", func(t *testing.T) {
+		policy, err := ParsePolicy(strings.NewReader(`
+currencies:
+  usd:
+    daily_amount: 1000
+    weekly_amount: $4000.50
+    max_daily_deposits: 2
+`))
+		assert.NoError(t, err)
+		assert.Equal(t, Limits{DailyAmount: 1000 * Dollar, WeeklyAmount: 4000*Dollar + 50*Cent, MaxDailyDeposits: 2}, policy.Currencies["USD"])
+	})
+
+	t.Run("ParsePolicy should read JSON policies", func(t *testing.T) {
+		policy, err := ParsePolicy(strings.NewReader(`{"currencies":{"EUR":{"daily_amount":"100.00","weekly_amount":"200","max_daily_deposits":1}},"base_currency":"eur"}`))
+		assert.NoError(t, err)
+		assert.Equal(t, Limits{DailyAmount: 100 * Dollar, WeeklyAmount: 200 * Dollar, MaxDailyDeposits: 1}, policy.Currencies["EUR"])
+		assert.Equal(t, "EUR", policy.BaseCurrency)
+	})
+
+	t.Run("ParsePolicy should return an error for unknown fields", func(t *testing.T) {
+		_, err := ParsePolicy(strings.NewReader("currencies:\n  USD:\n    daily_limit: 1000\n"))
+		assert.Error(t, err)
+	})
+
+	t.Run("ParsePolicy should return an error for malformed amounts", func(t *testing.T) {
+		_, err := ParsePolicy(strings.NewReader("currencies:\n  USD:\n    daily_amount: lots\n"))
+		assert.Error(t, err)
+	})
+}
+
+func TestPolicy_Validate(t *testing.T) {
+	t.Run("Validate should accept the default policy", func(t *testing.T) {
+		assert.NoError(t, DefaultPolicy().Validate())
+	})
+
+	t.Run("Validate should return an error for unenforceable policies", func(t *testing.T) {
+		limits := Limits{DailyAmount: 5000 * Dollar, WeeklyAmount: 20000 * Dollar, MaxDailyDeposits: 3}
+
+		policies := map[string]Policy{
+			"no currencies":       {},
+			"unknown currency":    {Currencies: map[string]Limits{"XYZ": limits}},
+			"lower case currency": {Currencies: map[string]Limits{"usd": limits}},
+			"zero amount":         {Currencies: map[string]Limits{"USD": {DailyAmount: 0, WeeklyAmount: 20000 * Dollar, MaxDailyDeposits: 3}}},
+			"zero deposits":       {Currencies: map[string]Limits{"USD": {DailyAmount: 5000 * Dollar, WeeklyAmount: 20000 * Dollar, MaxDailyDeposits: 0}}},
+			"daily over weekly":   {Currencies: map[string]Limits{"USD": {DailyAmount: 5000 * Dollar, WeeklyAmount: 4000 * Dollar, MaxDailyDeposits: 3}}},
+			"base without limits": {Currencies: map[string]Limits{"USD": limits}, BaseCurrency: "EUR", Rates: NewStaticRates()},
+			"base without rates":  {Currencies: map[string]Limits{"USD": limits}, BaseCurrency: "USD"},
+		}
+
+		for name, policy := range policies {
+			assert.Error(t, policy.Validate(), name)
+		}
+	})
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name string, contents string) string {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, ioutil.WriteFile(path, []byte(contents), 0644))
+		return path
+	}
+
+	writeFile("rates.csv", "EUR,USD,1.25\n")
+	path := writeFile("policy.yaml", `
+base_currency: USD
+rates_file: rates.csv
+currencies:
+  USD: {daily_amount: 100, weekly_amount: 1000, max_daily_deposits: 3}
+  EUR: {daily_amount: 100, weekly_amount: 1000, max_daily_deposits: 3}
+`)
+
+	t.Run("LoadPolicy should load the rates file relative to the policy", func(t *testing.T) {
+		policy, err := LoadPolicy(path)
+		assert.NoError(t, err)
+
+		v := NewValidatorWithPolicy(policy)
+		first := newDeposit("1", "1", "€80.00", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC))
+		second := newDeposit("2", "1", "$0.01", time.Date(2021, 1, 9, 11, 0, 0, 0, time.UTC))
+		assert.True(t, v.Validate(&first).Accepted)
+		assert.False(t, v.Validate(&second).Accepted)
+	})
+
+	t.Run("LoadPolicy should return an error for invalid policies", func(t *testing.T) {
+		_, err := LoadPolicy(writeFile("invalid.yaml", "currencies: {}\n"))
+		assert.Error(t, err)
+
+		_, err = LoadPolicy(filepath.Join(dir, "missing.yaml"))
+		assert.Error(t, err)
+	})
+}
+
+func TestParsePolicy_Windows(t *testing.T) {
+	t.Run("ParsePolicy should read monthly and rolling limits", func(t *testing.T) {
+		policy, err := ParsePolicy(strings.NewReader(`
+currencies:
+  USD:
+    daily_amount: 5000
+    weekly_amount: 20000
+    max_daily_deposits: 3
+    monthly_amount: 60000
+    rolling:
+      - {window: 24h, amount: 5000}
+      - {window: 720h, max_deposits: 30}
+`))
+		assert.NoError(t, err)
+		assert.NoError(t, policy.Validate())
+
+		limits := policy.Currencies["USD"]
+		assert.Equal(t, 60000*Dollar, limits.MonthlyAmount)
+		assert.Equal(t, []RollingLimit{{24 * time.Hour, 5000 * Dollar, 0}, {720 * time.Hour, 0, 30}}, limits.Rolling)
+	})
+
+	t.Run("Validate should return an error for empty rolling windows", func(t *testing.T) {
+		policy := DefaultPolicy()
+		limits := policy.Currencies["USD"]
+		limits.Rolling = []RollingLimit{{Amount: 100 * Dollar}}
+		policy.Currencies["USD"] = limits
+
+		assert.Error(t, policy.Validate())
+	})
+}
@@ -0,0 +1,179 @@
+package deposit
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// A RateSource provides the exchange rates used to convert deposits into a base currency
+type RateSource interface {
+	Rate(from string, to string, at time.Time) (Rate, error)
+}
+
+// A Rate is an exact exchange rate, i.e. the amount of the target currency bought by one
+// unit of the source currency
+type Rate struct {
+	value *big.Rat
+}
+
+// identityRate is used when a deposit is already in the base currency
+var identityRate = Rate{big.NewRat(1, 1)}
+
+// ParseRate parses a positive decimal exchange rate such as "1.18"
+func ParseRate(s string) (Rate, error) {
+	value, ok := new(big.Rat).SetString(strings.TrimSpace(s))
+	if !ok || value.Sign() <= 0 {
+		return Rate{}, fmt.Errorf("invalid exchange rate %q", s)
+	}
+
+	return Rate{value}, nil
+}
+
+// Convert converts an amount using the rate, rounding half a cent away from zero
+func (r Rate) Convert(m Money) Money {
+	converted := new(big.Rat).Mul(new(big.Rat).SetInt64(int64(m)), r.value)
+
+	quotient, remainder := new(big.Int).QuoRem(converted.Num(), converted.Denom(), new(big.Int))
+	if remainder.Lsh(remainder.Abs(remainder), 1).Cmp(converted.Denom()) >= 0 {
+		quotient.Add(quotient, big.NewInt(int64(converted.Sign())))
+	}
+
+	return Money(quotient.Int64())
+}
+
+// Inverse returns the rate for converting in the opposite direction
+func (r Rate) Inverse() Rate {
+	return Rate{new(big.Rat).Inv(r.value)}
+}
+
+// String formats the rate as a decimal with up to eight decimal places
+func (r Rate) String() string {
+	if r.value == nil {
+		return "0"
+	}
+
+	s := strings.TrimRight(r.value.FloatString(8), "0")
+	return strings.TrimSuffix(s, ".")
+}
+
+// MarshalJSON encodes the rate as a decimal string so that no precision is lost
+func (r Rate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+// UnmarshalJSON decodes a rate encoded as a decimal string
+func (r *Rate) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return errors.New("exchange rate must be a string")
+	}
+
+	rate, err := ParseRate(s)
+	if err != nil {
+		return err
+	}
+
+	*r = rate
+	return nil
+}
+
+// MissingRateError is returned when there is no exchange rate between two currencies
+type MissingRateError struct {
+	From string
+	To   string
+}
+
+func (e *MissingRateError) Error() string {
+	return fmt.Sprintf("no exchange rate from %s to %s", e.From, e.To)
+}
+
+type currencyPair struct {
+	from string
+	to   string
+}
+
+// StaticRates is a fixed table of exchange rates for use when no live rates are available.
+// The same rates are returned regardless of when the deposit was made.
+type StaticRates struct {
+	rates map[currencyPair]Rate
+}
+
+func NewStaticRates() *StaticRates {
+	return &StaticRates{rates: make(map[currencyPair]Rate)}
+}
+
+// Add records the rate for converting from one currency to another
+func (s *StaticRates) Add(from string, to string, rate Rate) {
+	s.rates[currencyPair{strings.ToUpper(from), strings.ToUpper(to)}] = rate
+}
+
+// Rate returns the rate from one currency to another, inverting the opposite rate if
+// only that one is known
+func (s *StaticRates) Rate(from string, to string, at time.Time) (Rate, error) {
+	from, to = strings.ToUpper(from), strings.ToUpper(to)
+
+	if from == to {
+		return identityRate, nil
+	}
+
+	if rate, ok := s.rates[currencyPair{from, to}]; ok {
+		return rate, nil
+	}
+
+	if rate, ok := s.rates[currencyPair{to, from}]; ok {
+		return rate.Inverse(), nil
+	}
+
+	return Rate{}, &MissingRateError{from, to}
+}
+
+// ParseRatesCSV reads a table of exchange rates with one "from,to,rate" record per line,
+// e.g. "EUR,USD,1.18". Blank lines and lines starting with # are ignored.
+func ParseRatesCSV(r io.Reader) (*StaticRates, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+
+	rates := NewStaticRates()
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rates, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, code := range record[:2] {
+			if _, err := LookupCurrency(code); err != nil {
+				return nil, err
+			}
+		}
+
+		rate, err := ParseRate(record[2])
+		if err != nil {
+			return nil, err
+		}
+
+		rates.Add(record[0], record[1], rate)
+	}
+}
+
+// LoadRatesFile reads a CSV table of exchange rates from a file
+func LoadRatesFile(path string) (*StaticRates, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ParseRatesCSV(file)
+}
@@ -0,0 +1,121 @@
+package deposit
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mustParseRate(t *testing.T, s string) Rate {
+	rate, err := ParseRate(s)
+	assert.NoError(t, err)
+	return rate
+}
+
+func TestRate(t *testing.T) {
+	t.Run("ParseRate should reject rates that are not positive decimals", func(t *testing.T) {
+		for _, input := range []string{"", "abc", "0", "-1.2"} {
+			_, err := ParseRate(input)
+			assert.Error(t, err, input)
+		}
+	})
+
+	t.Run("Convert should round to the nearest cent", func(t *testing.T) {
+		rate := mustParseRate(t, "1.185")
+		assert.Equal(t, Money(1185), rate.Convert(1000))
+		assert.Equal(t, Money(1), rate.Convert(1))
+		assert.Equal(t, Money(2), mustParseRate(t, "1.5").Convert(1))
+		assert.Equal(t, Money(-2), mustParseRate(t, "1.5").Convert(-1))
+	})
+
+	t.Run("Inverse should convert in the opposite direction", func(t *testing.T) {
+		rate := mustParseRate(t, "1.25")
+		assert.Equal(t, "0.8", rate.Inverse().String())
+		assert.Equal(t, Money(800), rate.Inverse().Convert(1000))
+	})
+
+	t.Run("String should format the rate as a decimal", func(t *testing.T) {
+		assert.Equal(t, "1.18", mustParseRate(t, "1.1800").String())
+		assert.Equal(t, "2", mustParseRate(t, "2").String())
+	})
+}
+
+func TestStaticRates(t *testing.T) {
+	rates, err := ParseRatesCSV(strings.NewReader("# from,to,rate\nEUR,USD,1.25\n\nGBP, USD, 1.5\n"))
+	assert.NoError(t, err)
+
+	t.Run("Rate should return the rate between two currencies", func(t *testing.T) {
+		rate, err := rates.Rate("EUR", "USD", time.Time{})
+		assert.NoError(t, err)
+		assert.Equal(t, "1.25", rate.String())
+	})
+
+	t.Run("Rate should invert the opposite rate", func(t *testing.T) {
+		rate, err := rates.Rate("USD", "EUR", time.Time{})
+		assert.NoError(t, err)
+		assert.Equal(t, "0.8", rate.String())
+	})
+
+	t.Run("Rate should return the identity rate for the same currency", func(t *testing.T) {
+		rate, err := rates.Rate("USD", "USD", time.Time{})
+		assert.NoError(t, err)
+		assert.Equal(t, "1", rate.String())
+	})
+
+	t.Run("Rate should return an error if there is no rate", func(t *testing.T) {
+		_, err := rates.Rate("EUR", "GBP", time.Time{})
+		assert.EqualError(t, err, "no exchange rate from EUR to GBP")
+	})
+
+	t.Run("ParseRatesCSV should return an error for malformed tables", func(t *testing.T) {
+		for _, input := range []string{"EUR,USD", "EUR,USD,abc", "XYZ,USD,1.2"} {
+			_, err := ParseRatesCSV(strings.NewReader(input))
+			assert.Error(t, err, input)
+		}
+	})
+}
+
+func TestValidate_BaseCurrency(t *testing.T) {
+	rates := NewStaticRates()
+	rates.Add("EUR", "USD", mustParseRate(t, "1.25"))
+
+	policy := DefaultPolicy()
+	policy.BaseCurrency = "USD"
+	policy.Rates = rates
+	v := NewValidatorWithPolicy(policy)
+
+	t.Run("Validate should record the conversion into the base currency", func(t *testing.T) {
+		deposit := newDeposit("1", "1", "€1000.00", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC))
+		decision := v.Validate(&deposit)
+		assert.True(t, decision.Accepted)
+		assert.Equal(t, &Conversion{"USD", 1250 * Dollar, mustParseRate(t, "1.25")}, decision.Conversion)
+	})
+
+	t.Run("Validate should apply the base currency limits to combined deposits", func(t *testing.T) {
+		second := newDeposit("2", "1", "$3750.00", time.Date(2021, 1, 9, 11, 0, 0, 0, time.UTC))
+		third := newDeposit("3", "1", "€0.01", time.Date(2021, 1, 9, 12, 0, 0, 0, time.UTC))
+		assert.True(t, v.Validate(&second).Accepted)
+		assert.Equal(t, []Reason{DailyAmountExceeded}, v.Validate(&third).Reasons)
+	})
+
+	t.Run("Validate should reject deposits that cannot be converted", func(t *testing.T) {
+		deposit := newDeposit("4", "2", "£1.00", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC))
+		decision := v.Validate(&deposit)
+		assert.Equal(t, []Reason{MissingExchangeRate}, decision.Reasons)
+		assert.Nil(t, decision.Conversion)
+	})
+
+	t.Run("Conversion should only decode amounts in the grammar of ParseAmount", func(t *testing.T) {
+		var conversion Conversion
+		assert.NoError(t, json.Unmarshal([]byte(`{"currency":"EUR","amount":"€1,250.00","rate":"1.25"}`), &conversion))
+		assert.Equal(t, Conversion{"EUR", 1250 * Dollar, mustParseRate(t, "1.25")}, conversion)
+
+		for _, amount := range []string{"-€1.00", "€1e4", "$1.00", "€1.001"} {
+			data := `{"currency":"EUR","amount":"` + amount + `","rate":"1.25"}`
+			assert.Error(t, json.Unmarshal([]byte(data), &conversion), amount)
+		}
+	})
+}
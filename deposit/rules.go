@@ -0,0 +1,154 @@
+package deposit
+
+import (
+	"fmt"
+	"time"
+)
+
+// A Rule decides whether a deposit can be accepted into a customer's account. Rules are
+// evaluated in order, and if every rule accepts the deposit each of them commits it. The
+// account's ledgers are updated by the validator, so Commit is only needed by rules that
+// keep their own state. A rule is shared by every customer, so any state it keeps must be
+// safe for concurrent use.
+type Rule interface {
+	// Evaluate returns the reason the load cannot be accepted, or an empty Reason
+	Evaluate(load Load, account *Account) Reason
+
+	// Commit is called once every rule has accepted the load
+	Commit(load Load, account *Account)
+}
+
+// DefaultRules are the names of the rules a validator runs if its policy lists none
+var DefaultRules = []string{"daily_count", "daily_amount", "weekly_amount", "monthly_count", "monthly_amount", "rolling"}
+
+// The rules that can be named in a policy
+var registeredRules = map[string]Rule{
+	"daily_count":    DailyCountRule{},
+	"daily_amount":   DailyAmountRule{},
+	"weekly_amount":  WeeklyAmountRule{},
+	"monthly_count":  MonthlyCountRule{},
+	"monthly_amount": MonthlyAmountRule{},
+	"rolling":        RollingRule{},
+}
+
+// RegisterRule makes a rule available to policies under the given name. It is not safe to
+// call while validators are being created.
+func RegisterRule(name string, rule Rule) {
+	registeredRules[name] = rule
+}
+
+// lookupRules returns the registered rules with the given names
+func lookupRules(names []string) ([]Rule, error) {
+	rules := make([]Rule, len(names))
+
+	for i, name := range names {
+		rule, ok := registeredRules[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown rule %q", name)
+		}
+
+		rules[i] = rule
+	}
+
+	return rules, nil
+}
+
+// DailyCountRule limits the number of deposits a customer can make in a day
+type DailyCountRule struct{}
+
+func (DailyCountRule) Evaluate(load Load, account *Account) Reason {
+	if account.Daily.Deposits >= account.Limits.MaxDailyDeposits {
+		return DailyCountExceeded
+	}
+
+	return ""
+}
+
+func (DailyCountRule) Commit(load Load, account *Account) {}
+
+// DailyAmountRule limits the total amount a customer can deposit in a day
+type DailyAmountRule struct{}
+
+func (DailyAmountRule) Evaluate(load Load, account *Account) Reason {
+	if account.Daily.Total+load.Amount > account.Limits.DailyAmount {
+		return DailyAmountExceeded
+	}
+
+	return ""
+}
+
+func (DailyAmountRule) Commit(load Load, account *Account) {}
+
+// WeeklyAmountRule limits the total amount a customer can deposit in a week
+type WeeklyAmountRule struct{}
+
+func (WeeklyAmountRule) Evaluate(load Load, account *Account) Reason {
+	if account.Weekly.Total+load.Amount > account.Limits.WeeklyAmount {
+		return WeeklyAmountExceeded
+	}
+
+	return ""
+}
+
+func (WeeklyAmountRule) Commit(load Load, account *Account) {}
+
+// MonthlyCountRule limits the number of deposits a customer can make in a calendar month
+type MonthlyCountRule struct{}
+
+func (MonthlyCountRule) Evaluate(load Load, account *Account) Reason {
+	if max := account.Limits.MaxMonthlyDeposits; max > 0 && account.Monthly.Deposits >= max {
+		return MonthlyCountExceeded
+	}
+
+	return ""
+}
+
+func (MonthlyCountRule) Commit(load Load, account *Account) {}
+
+// MonthlyAmountRule limits the total amount a customer can deposit in a calendar month
+type MonthlyAmountRule struct{}
+
+func (MonthlyAmountRule) Evaluate(load Load, account *Account) Reason {
+	if max := account.Limits.MonthlyAmount; max > 0 && account.Monthly.Total+load.Amount > max {
+		return MonthlyAmountExceeded
+	}
+
+	return ""
+}
+
+func (MonthlyAmountRule) Commit(load Load, account *Account) {}
+
+// RollingRule enforces the account's rolling limits over every window that would contain
+// the deposit
+type RollingRule struct{}
+
+func (RollingRule) Evaluate(load Load, account *Account) Reason {
+	t := load.Deposit.Time
+
+	for _, limit := range account.Limits.Rolling {
+		// Besides the window ending at the deposit, a late deposit also falls into the
+		// windows ending at each of the later deposits
+		ends := []time.Time{t}
+		for _, entry := range account.History {
+			if entry.Time.After(t) && entry.Time.Before(t.Add(limit.Window)) {
+				ends = append(ends, entry.Time)
+			}
+		}
+
+		for _, end := range ends {
+			window := account.Window(end.Add(-limit.Window), end)
+
+			if limit.MaxDeposits > 0 && window.Deposits >= limit.MaxDeposits {
+				return RollingCountExceeded
+			}
+
+			if limit.Amount > 0 && window.Total+load.Amount > limit.Amount {
+				return RollingAmountExceeded
+			}
+		}
+	}
+
+	return ""
+}
+
+func (RollingRule) Commit(load Load, account *Account) {}
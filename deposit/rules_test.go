@@ -0,0 +1,145 @@
+package deposit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// largeDepositRule rejects deposits over a fixed amount and counts the deposits it commits
+type largeDepositRule struct {
+	max       Money
+	committed map[string]int
+}
+
+const largeDeposit Reason = "LARGE_DEPOSIT"
+
+func (r *largeDepositRule) Evaluate(load Load, account *Account) Reason {
+	if load.Amount > r.max {
+		return largeDeposit
+	}
+
+	return ""
+}
+
+func (r *largeDepositRule) Commit(load Load, account *Account) {
+	r.committed[account.CustomerID]++
+}
+
+func TestNewValidatorWithRules(t *testing.T) {
+	rule := &largeDepositRule{1000 * Dollar, make(map[string]int)}
+	v := NewValidatorWithRules(DefaultPolicy(), DailyAmountRule{}, rule)
+
+	t.Run("Validate should only run the given rules", func(t *testing.T) {
+		for i, id := range []string{"1", "2", "3", "4"} {
+			deposit := newDeposit(id, "1", "$100.00", time.Date(2021, 1, 9, 10, i, 0, 0, time.UTC))
+			assert.True(t, v.Validate(&deposit).Accepted)
+		}
+
+		assert.Equal(t, 4, rule.committed["1"])
+	})
+
+	t.Run("Validate should report the reasons from every rule in order", func(t *testing.T) {
+		deposit := newDeposit("5", "1", "$4700.00", time.Date(2021, 1, 9, 11, 0, 0, 0, time.UTC))
+		assert.Equal(t, []Reason{DailyAmountExceeded, largeDeposit}, v.Validate(&deposit).Reasons)
+	})
+
+	t.Run("Validate should not commit rejected deposits", func(t *testing.T) {
+		assert.Equal(t, 4, rule.committed["1"])
+	})
+}
+
+func TestRegisterRule(t *testing.T) {
+	RegisterRule("large_deposit", &largeDepositRule{500 * Dollar, make(map[string]int)})
+
+	policy := DefaultPolicy()
+	policy.Rules = []string{"large_deposit", "daily_count"}
+
+	t.Run("Validate should accept policies naming registered rules", func(t *testing.T) {
+		assert.NoError(t, policy.Validate())
+	})
+
+	t.Run("Validate should run the rules named by the policy", func(t *testing.T) {
+		v := NewValidatorWithPolicy(policy)
+
+		deposit := newDeposit("1", "1", "$500.01", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC))
+		assert.Equal(t, []Reason{largeDeposit}, v.Validate(&deposit).Reasons)
+	})
+
+	t.Run("Validate should return an error for unknown rules", func(t *testing.T) {
+		policy.Rules = []string{"credit_score"}
+		assert.EqualError(t, policy.Validate(), `unknown rule "credit_score"`)
+	})
+}
+
+func TestMonthlyRules(t *testing.T) {
+	policy := DefaultPolicy()
+	limits := policy.Currencies["USD"]
+	limits.MonthlyAmount = 6000 * Dollar
+	limits.MaxMonthlyDeposits = 3
+	policy.Currencies["USD"] = limits
+
+	v := NewValidatorWithPolicy(policy)
+
+	t.Run("Validate should return false if the customer deposits more than the monthly limit", func(t *testing.T) {
+		first := newDeposit("1", "1", "$4000.00", time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC))
+		second := newDeposit("2", "1", "$2000.00", time.Date(2021, 1, 11, 0, 0, 0, 0, time.UTC))
+		third := newDeposit("3", "1", "$0.01", time.Date(2021, 1, 18, 0, 0, 0, 0, time.UTC))
+		assert.True(t, v.Validate(&first).Accepted)
+		assert.True(t, v.Validate(&second).Accepted)
+		assert.Equal(t, []Reason{MonthlyAmountExceeded}, v.Validate(&third).Reasons)
+	})
+
+	t.Run("Validate should return false if the customer deposits too many times in a month", func(t *testing.T) {
+		for i, id := range []string{"4", "5", "6"} {
+			deposit := newDeposit(id, "2", "$1.00", time.Date(2021, 1, 4+7*i, 0, 0, 0, 0, time.UTC))
+			assert.True(t, v.Validate(&deposit).Accepted)
+		}
+
+		deposit := newDeposit("7", "2", "$1.00", time.Date(2021, 1, 31, 23, 59, 59, 0, time.UTC))
+		assert.Equal(t, []Reason{MonthlyCountExceeded}, v.Validate(&deposit).Reasons)
+	})
+
+	t.Run("Validate should return true once the ledger is reset the next month", func(t *testing.T) {
+		first := newDeposit("8", "1", "$0.01", time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC))
+		second := newDeposit("9", "2", "$1.00", time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC))
+		assert.True(t, v.Validate(&first).Accepted)
+		assert.True(t, v.Validate(&second).Accepted)
+	})
+}
+
+func TestRollingRule(t *testing.T) {
+	policy := DefaultPolicy()
+	limits := policy.Currencies["USD"]
+	limits.Rolling = []RollingLimit{
+		{Window: 24 * time.Hour, Amount: 5000 * Dollar},
+		{Window: 7 * 24 * time.Hour, MaxDeposits: 4},
+	}
+	policy.Currencies["USD"] = limits
+
+	v := NewValidatorWithPolicy(policy)
+
+	t.Run("Validate should apply rolling limits across midnight", func(t *testing.T) {
+		first := newDeposit("1", "1", "$4000.00", time.Date(2021, 1, 9, 20, 0, 0, 0, time.UTC))
+		second := newDeposit("2", "1", "$1500.00", time.Date(2021, 1, 10, 1, 0, 0, 0, time.UTC))
+		assert.True(t, v.Validate(&first).Accepted)
+		assert.Equal(t, []Reason{RollingAmountExceeded}, v.Validate(&second).Reasons)
+	})
+
+	t.Run("Validate should return true once the earlier deposits leave the window", func(t *testing.T) {
+		deposit := newDeposit("3", "1", "$1500.00", time.Date(2021, 1, 10, 20, 0, 0, 0, time.UTC))
+		assert.True(t, v.Validate(&deposit).Accepted)
+	})
+
+	t.Run("Validate should return false if the customer deposits too many times in the window", func(t *testing.T) {
+		third := newDeposit("4", "1", "$1.00", time.Date(2021, 1, 12, 0, 0, 0, 0, time.UTC))
+		fourth := newDeposit("5", "1", "$1.00", time.Date(2021, 1, 14, 0, 0, 0, 0, time.UTC))
+		fifth := newDeposit("6", "1", "$1.00", time.Date(2021, 1, 16, 0, 0, 0, 0, time.UTC))
+		sixth := newDeposit("7", "1", "$1.00", time.Date(2021, 1, 16, 20, 0, 0, 0, time.UTC))
+		assert.True(t, v.Validate(&third).Accepted)
+		assert.True(t, v.Validate(&fourth).Accepted)
+		assert.Equal(t, []Reason{RollingCountExceeded}, v.Validate(&fifth).Reasons)
+		assert.True(t, v.Validate(&sixth).Accepted)
+	})
+}
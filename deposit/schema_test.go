@@ -0,0 +1,375 @@
+package deposit
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"math/rand"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// readSchema reads one of the published JSON schemas
+func readSchema(t *testing.T, name string) map[string]interface{} {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var schema map[string]interface{}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	return schema
+}
+
+// checkSchema returns an error if the value does not match the schema. Only the keywords
+// the published schemas use are understood, and any other keyword is an error so that the
+// tests cannot pass by ignoring part of a schema.
+func checkSchema(schema map[string]interface{}, value interface{}) error {
+	for keyword := range schema {
+		switch keyword {
+		case "$schema", "$id", "title", "description", "format":
+		case "type", "properties", "required", "additionalProperties", "enum", "pattern", "items", "minItems", "uniqueItems":
+		default:
+			return fmt.Errorf("unsupported keyword %q", keyword)
+		}
+	}
+
+	if expected, ok := schema["type"]; ok && schemaType(value) != expected {
+		return fmt.Errorf("%v is not a %v", value, expected)
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, allowed := range enum {
+			found = found || reflect.DeepEqual(allowed, value)
+		}
+		if !found {
+			return fmt.Errorf("%v is not one of %v", value, enum)
+		}
+	}
+
+	if pattern, ok := schema["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(value.(string)) {
+		return fmt.Errorf("%q does not match %s", value, pattern)
+	}
+
+	if object, ok := value.(map[string]interface{}); ok {
+		properties, _ := schema["properties"].(map[string]interface{})
+		for name, field := range object {
+			property, ok := properties[name].(map[string]interface{})
+			if !ok {
+				if schema["additionalProperties"] == false {
+					return fmt.Errorf("unknown property %q", name)
+				}
+				continue
+			}
+
+			if err := checkSchema(property, field); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := object[name.(string)]; !ok {
+				return fmt.Errorf("missing %s", name)
+			}
+		}
+	}
+
+	if array, ok := value.([]interface{}); ok {
+		if min, ok := schema["minItems"].(float64); ok && len(array) < int(min) {
+			return fmt.Errorf("fewer than %v items", min)
+		}
+
+		for i, item := range array {
+			if items, ok := schema["items"].(map[string]interface{}); ok {
+				if err := checkSchema(items, item); err != nil {
+					return fmt.Errorf("item %d: %v", i, err)
+				}
+			}
+
+			for _, other := range array[:i] {
+				if schema["uniqueItems"] == true && reflect.DeepEqual(item, other) {
+					return fmt.Errorf("%v is repeated", item)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// schemaType returns the JSON schema type of a decoded JSON value
+func schemaType(value interface{}) string {
+	switch value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	default:
+		return "null"
+	}
+}
+
+// checkPayload checks the JSON payload against the schema
+func checkPayload(schema map[string]interface{}, payload string) error {
+	var value interface{}
+	if err := json.Unmarshal([]byte(payload), &value); err != nil {
+		return err
+	}
+
+	return checkSchema(schema, value)
+}
+
+// jsonFields returns the names of the fields a struct is encoded with
+func jsonFields(v interface{}) []string {
+	var fields []string
+
+	typ := reflect.TypeOf(v)
+	for i := 0; i < typ.NumField(); i++ {
+		name := strings.Split(typ.Field(i).Tag.Get("json"), ",")[0]
+		if name != "-" {
+			fields = append(fields, name)
+		}
+	}
+
+	sort.Strings(fields)
+	return fields
+}
+
+// schemaFields returns the names of the properties in a schema and those it requires
+func schemaFields(schema map[string]interface{}) (properties []string, required []string) {
+	for name := range schema["properties"].(map[string]interface{}) {
+		properties = append(properties, name)
+	}
+
+	for _, name := range schema["required"].([]interface{}) {
+		required = append(required, name.(string))
+	}
+
+	sort.Strings(properties)
+	sort.Strings(required)
+	return properties, required
+}
+
+// declaredReasons returns every Reason constant declared in decision.go
+func declaredReasons(t *testing.T) []interface{} {
+	file, err := parser.ParseFile(token.NewFileSet(), "decision.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reasons []interface{}
+	ast.Inspect(file, func(node ast.Node) bool {
+		spec, ok := node.(*ast.ValueSpec)
+		if !ok {
+			return true
+		}
+
+		if typ, ok := spec.Type.(*ast.Ident); ok && typ.Name == "Reason" {
+			reason, _ := strconv.Unquote(spec.Values[0].(*ast.BasicLit).Value)
+			reasons = append(reasons, reason)
+		}
+		return true
+	})
+
+	return reasons
+}
+
+func TestLoadSchema(t *testing.T) {
+	schema := readSchema(t, "load.schema.json")
+
+	t.Run("The load schema should describe the fields of a deposit", func(t *testing.T) {
+		properties, required := schemaFields(schema)
+		assert.Equal(t, jsonFields(Deposit{}), properties)
+
+		assert.ElementsMatch(t, knownFields, properties)
+		assert.ElementsMatch(t, requiredFields, required)
+	})
+
+	t.Run("The load schema should only allow the supported currencies", func(t *testing.T) {
+		var codes []interface{}
+		for code := range currencies {
+			codes = append(codes, code)
+		}
+
+		enum := schema["properties"].(map[string]interface{})["currency"].(map[string]interface{})["enum"]
+		assert.ElementsMatch(t, codes, enum)
+	})
+
+	t.Run("ParseJsonStrict should accept exactly the loads the schema accepts", func(t *testing.T) {
+		payloads := []string{
+			`{"id":"1","customer_id":"1","load_amount":"$1,234.56","time":"2000-01-01T00:00:00Z"}`,
+			`{"id":"1","customer_id":"1","load_amount":"12.00","currency":"EUR","time":"2000-01-01T00:00:00.5+01:00"}`,
+			`{"id":"","customer_id":"","load_amount":"$0","time":"2000-01-01T00:00:00Z"}`,
+			`{"id":"1","customer_id":"1","load_amount":"$1.00","time":"2000-01-01T00:00:00Z","ParsedAmount":100}`,
+			`{"id":"1","customer_id":"1","load_amount":"$1.00","time":"2000-01-01T00:00:00Z","note":"x"}`,
+			`{"id":"1","customer_id":"1","load_amount":"$1.00","currency":null,"time":"2000-01-01T00:00:00Z"}`,
+			`{"id":"1","customer_id":"1","load_amount":"$1.00","currency":"usd","time":"2000-01-01T00:00:00Z"}`,
+			`{"ID":"1","customer_id":"1","load_amount":"$1.00","time":"2000-01-01T00:00:00Z"}`,
+			`{"id":1,"customer_id":"1","load_amount":"$1.00","time":"2000-01-01T00:00:00Z"}`,
+			`{"id":"1","customer_id":"1","load_amount":"$1.00"}`,
+			`{"id":"1","customer_id":"1","load_amount":"1e3","time":"2000-01-01T00:00:00Z"}`,
+			`{"id":"1","customer_id":"1","load_amount":"$1.00","time":"2000-01-01"}`,
+			`["id","customer_id","load_amount","time"]`,
+		}
+
+		for _, payload := range payloads {
+			_, err := ParseJsonStrict(payload)
+			assert.Equal(t, checkPayload(schema, payload) == nil, err == nil, "%s: %v", payload, err)
+		}
+	})
+
+	t.Run("ParseJsonStrict should agree with the schema on random loads", func(t *testing.T) {
+		r := rand.New(rand.NewSource(1))
+		accepted := 0
+
+		for i := 0; i < 20000; i++ {
+			fields := randomLoad(r)
+
+			// A currency that disagrees with the amount's prefix cannot be described by the schema
+			if code, ok := fields["currency"].(string); ok {
+				if prefix, _, err := splitCurrency(fmt.Sprint(fields["load_amount"])); err == nil && prefix.Code != "" && prefix.Code != code {
+					continue
+				}
+			}
+
+			payload, _ := json.Marshal(fields)
+			_, err := ParseJsonStrict(string(payload))
+			schemaErr := checkPayload(schema, string(payload))
+			if !assert.Equal(t, schemaErr == nil, err == nil, "%s: %v %v", payload, err, schemaErr) {
+				return
+			}
+
+			if err == nil {
+				accepted++
+			}
+		}
+
+		// Both outcomes should be well represented
+		assert.Greater(t, accepted, 1000)
+		assert.Less(t, accepted, 19000)
+	})
+}
+
+// The values random loads are made of. Each field is usually given a value of the right
+// kind, but sometimes one of the wrong kind or left out.
+var (
+	loadStrings = []interface{}{"1", "abc", "", " ", "USD", "usd", "JPY", nil, 1.0, true}
+	loadTimes   = []interface{}{"2000-01-01T00:00:00Z", "2021-01-09T10:00:00.123-05:00", "2000-01-01", "yesterday", nil, 946684800.0}
+	loadExtras  = []string{"ParsedAmount", "ID", "Customer_ID", "note"}
+)
+
+// randomLoad returns the fields of a random load payload
+func randomLoad(r *rand.Rand) map[string]interface{} {
+	fields := map[string]interface{}{}
+	pick := func(values []interface{}) interface{} {
+		return values[r.Intn(len(values))]
+	}
+
+	for _, field := range []string{"id", "customer_id"} {
+		if r.Intn(20) > 0 {
+			fields[field] = pick(loadStrings)
+			if r.Intn(2) == 0 {
+				fields[field] = fmt.Sprint(r.Intn(1000))
+			}
+		}
+	}
+
+	if r.Intn(20) > 0 {
+		fields["load_amount"] = randomAmount(r)
+		if r.Intn(2) == 0 {
+			fields["load_amount"] = Money(r.Int63n(1e9)).String()
+		}
+	}
+
+	if r.Intn(4) == 0 {
+		fields["currency"] = pick(append(loadStrings, "EUR", "GBP"))
+	}
+
+	if r.Intn(20) > 0 {
+		fields["time"] = pick(loadTimes)
+		if r.Intn(2) == 0 {
+			fields["time"] = time.Unix(r.Int63n(2e9), 0).UTC().Format(time.RFC3339)
+		}
+	}
+
+	if r.Intn(10) == 0 {
+		fields[loadExtras[r.Intn(len(loadExtras))]] = pick(loadStrings)
+	}
+
+	return fields
+}
+
+func TestDecisionSchema(t *testing.T) {
+	schema := readSchema(t, "decision.schema.json")
+
+	t.Run("The decision schema should describe the fields of a decision", func(t *testing.T) {
+		properties, required := schemaFields(schema)
+		assert.Equal(t, jsonFields(Decision{}), properties)
+		assert.Equal(t, []string{"accepted", "customer_id", "id"}, required)
+	})
+
+	t.Run("The decision schema should list every reason", func(t *testing.T) {
+		items := schema["properties"].(map[string]interface{})["reasons"].(map[string]interface{})["items"]
+		assert.ElementsMatch(t, declaredReasons(t), items.(map[string]interface{})["enum"])
+	})
+
+	t.Run("Validate should return decisions that match the schema", func(t *testing.T) {
+		rates := NewStaticRates()
+		rates.Add("EUR", "USD", mustParseRate(t, "1.18372"))
+
+		policy := DefaultPolicy()
+		policy.BaseCurrency = "USD"
+		policy.Rates = rates
+		v := NewValidatorWithPolicy(policy)
+
+		deposits := []Deposit{
+			newDeposit("1", "1", "€4000.00", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC)),
+			newDeposit("2", "1", "$1,000.00", time.Date(2021, 1, 9, 11, 0, 0, 0, time.UTC)),
+			newDeposit("3", "1", "$1.00", time.Date(2021, 1, 9, 12, 0, 0, 0, time.UTC)),
+			newDeposit("4", "1", "$1.00", time.Date(2021, 1, 9, 13, 0, 0, 0, time.UTC)),
+			newDeposit("5", "1", "JPY 100", time.Date(2021, 1, 9, 14, 0, 0, 0, time.UTC)),
+			newDeposit("", "1", "$1.00", time.Date(2021, 1, 9, 15, 0, 0, 0, time.UTC)),
+		}
+
+		for _, deposit := range deposits {
+			decision := v.Validate(&deposit)
+			payload, err := json.Marshal(decision)
+			assert.NoError(t, err)
+			assert.NoError(t, checkPayload(schema, string(payload)), string(payload))
+		}
+	})
+
+	t.Run("The decision schema should reject decisions that do not match it", func(t *testing.T) {
+		payloads := []string{
+			`{"id":"1","customer_id":"1"}`,
+			`{"id":"1","customer_id":"1","accepted":false,"reasons":["NOT_A_REASON"]}`,
+			`{"id":"1","customer_id":"1","accepted":false,"reasons":[]}`,
+			`{"id":"1","customer_id":"1","accepted":true,"conversion":{"currency":"USD","amount":"125.00","rate":"1.25"}}`,
+		}
+
+		for _, payload := range payloads {
+			assert.Error(t, checkPayload(schema, payload), payload)
+		}
+	})
+}
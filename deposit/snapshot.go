@@ -0,0 +1,221 @@
+package deposit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// The version of the format state is saved in. Version 1 recorded the IDs of validated
+// deposits without their times, and is still read.
+const snapshotVersion = 2
+
+// ErrSnapshotUnsupported is returned when taking or restoring a snapshot of a validator whose
+// store is not a Snapshotter
+var ErrSnapshotUnsupported = errors.New("the store does not support snapshots")
+
+// A Snapshotter is a store whose whole state can be saved to a file and restored later, e.g.
+// to checkpoint a long run or to seed a test environment
+type Snapshotter interface {
+	// WriteSnapshot writes the state of every customer at a single point in time
+	WriteSnapshot(w io.Writer) error
+
+	// ReadSnapshot replaces the state of the store with a snapshot
+	ReadSnapshot(r io.Reader) error
+}
+
+// A snapshot is the state of every customer in a store
+type snapshot struct {
+	Version   int                `json:"version"`
+	Sequence  uint64             `json:"sequence,omitempty"`
+	Customers []customerSnapshot `json:"customers"`
+}
+
+// A customerSnapshot is the state of a single customer. Forgotten is the time of the latest
+// validated deposit the customer has had forgotten, if any.
+type customerSnapshot struct {
+	CustomerID string             `json:"customer_id"`
+	Validated  []validatedDeposit `json:"validated,omitempty"`
+	Forgotten  *time.Time         `json:"forgotten,omitempty"`
+	Accounts   []accountSnapshot  `json:"accounts,omitempty"`
+}
+
+// A validatedDeposit is the ID and time of a deposit that has been validated, and the
+// decision made for it if one was saved. Deposits saved without a time are never forgotten.
+type validatedDeposit struct {
+	ID       string    `json:"id"`
+	Time     time.Time `json:"time"`
+	Decision *Decision `json:"decision,omitempty"`
+}
+
+// UnmarshalJSON reads a validated deposit, which version 1 saved as just its ID
+func (d *validatedDeposit) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		*d = validatedDeposit{}
+		return json.Unmarshal(data, &d.ID)
+	}
+
+	type plain validatedDeposit
+	return json.Unmarshal(data, (*plain)(d))
+}
+
+// An accountSnapshot is the saved part of an account. Its limits and ledgers are worked out
+// again when it is used.
+type accountSnapshot struct {
+	Currency string    `json:"currency"`
+	Latest   time.Time `json:"latest"`
+	History  []Entry   `json:"history"`
+}
+
+// readSnapshot reads a snapshot, returning an error if it is in an unknown version
+func readSnapshot(r io.Reader) (snapshot, error) {
+	var snap snapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return snapshot{}, fmt.Errorf("invalid snapshot: %w", err)
+	}
+
+	if snap.Version < 1 || snap.Version > snapshotVersion {
+		return snapshot{}, fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+
+	// Entries are kept in chronological order
+	for _, customer := range snap.Customers {
+		for _, account := range customer.Accounts {
+			history := account.History
+			sort.SliceStable(history, func(i, j int) bool { return history[i].Time.Before(history[j].Time) })
+		}
+	}
+
+	return snap, nil
+}
+
+func newAccountSnapshot(account *Account) accountSnapshot {
+	history := make([]Entry, len(account.History))
+	copy(history, account.History)
+
+	return accountSnapshot{Currency: account.Currency, Latest: account.Latest, History: history}
+}
+
+// WriteSnapshot writes the state of every customer to w. The deposits remembered by a store
+// with a filter cannot be listed, so it returns an error instead.
+func (s *MemoryStore) WriteSnapshot(w io.Writer) error {
+	if s.filtered() {
+		return errFilterSnapshot
+	}
+
+	s.lockAll()
+	snap := s.snapshot()
+	s.unlockAll()
+
+	return json.NewEncoder(w).Encode(snap)
+}
+
+// ReadSnapshot replaces the state of every customer with the snapshot read from r
+func (s *MemoryStore) ReadSnapshot(r io.Reader) error {
+	if s.filtered() {
+		return errFilterSnapshot
+	}
+
+	snap, err := readSnapshot(r)
+	if err != nil {
+		return err
+	}
+
+	s.lockAll()
+	defer s.unlockAll()
+
+	s.replace(snap)
+	return nil
+}
+
+// snapshot returns the state of every customer, ordered by customer ID. The caller must
+// hold the lock of every shard.
+func (s *MemoryStore) snapshot() snapshot {
+	snap := snapshot{Version: snapshotVersion, Customers: []customerSnapshot{}}
+
+	for _, shard := range s.allShards() {
+		for customerID, state := range shard.customers {
+			customer := customerSnapshot{CustomerID: customerID}
+			if !state.forgotten.IsZero() {
+				forgotten := state.forgotten
+				customer.Forgotten = &forgotten
+			}
+
+			for depositID, at := range state.validated {
+				customer.Validated = append(customer.Validated, validatedDeposit{depositID, at, state.decisions[depositID]})
+			}
+			sort.Slice(customer.Validated, func(i, j int) bool { return customer.Validated[i].ID < customer.Validated[j].ID })
+
+			for _, account := range state.accounts {
+				customer.Accounts = append(customer.Accounts, newAccountSnapshot(account))
+			}
+			sort.Slice(customer.Accounts, func(i, j int) bool { return customer.Accounts[i].Currency < customer.Accounts[j].Currency })
+
+			snap.Customers = append(snap.Customers, customer)
+		}
+	}
+
+	sort.Slice(snap.Customers, func(i, j int) bool { return snap.Customers[i].CustomerID < snap.Customers[j].CustomerID })
+	return snap
+}
+
+// restore adds the state of a customer to the store, replacing the accounts it includes.
+// The caller must hold the lock of the customer's shard.
+func (s *MemoryStore) restore(customer customerSnapshot) {
+	tx := &memoryTx{store: s, shard: s.shardFor(customer.CustomerID), customerID: customer.CustomerID}
+	state := tx.state()
+
+	for _, deposit := range customer.Validated {
+		if _, ok := state.validated[deposit.ID]; !ok {
+			tx.shard.validated++
+		}
+
+		state.validated[deposit.ID] = deposit.Time
+
+		if deposit.Decision != nil {
+			state.decisions[deposit.ID] = deposit.Decision
+		}
+	}
+
+	if customer.Forgotten != nil && customer.Forgotten.After(state.forgotten) {
+		state.forgotten = *customer.Forgotten
+	}
+
+	for _, account := range customer.Accounts {
+		state.accounts[account.Currency] = &Account{
+			CustomerID: customer.CustomerID,
+			Currency:   account.Currency,
+			History:    account.History,
+			Latest:     account.Latest,
+		}
+	}
+}
+
+// replace replaces the state of every customer with a snapshot. The caller must hold the
+// lock of every shard.
+func (s *MemoryStore) replace(snap snapshot) {
+	for _, shard := range s.allShards() {
+		shard.customers = make(map[string]*customerState)
+		shard.validated = 0
+	}
+
+	for _, customer := range snap.Customers {
+		s.restore(customer)
+	}
+}
+
+// lockAll locks every shard so that the store can be read or replaced as a whole
+func (s *MemoryStore) lockAll() {
+	for _, shard := range s.allShards() {
+		shard.mu.Lock()
+	}
+}
+
+func (s *MemoryStore) unlockAll() {
+	for _, shard := range s.allShards() {
+		shard.mu.Unlock()
+	}
+}
@@ -0,0 +1,84 @@
+package deposit
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshot(t *testing.T) {
+	v := NewValidator()
+	assert.Equal(t, []bool{true, true}, validateDeposits(v, "1", 4, 5))
+	assert.Equal(t, []bool{true}, validateDeposits(v, "2", 4))
+
+	var buf bytes.Buffer
+	assert.NoError(t, v.Snapshot(&buf))
+	snap := buf.String()
+
+	t.Run("Restore should carry the deposits and ledgers into another validator", func(t *testing.T) {
+		restored := NewValidator()
+		assert.NoError(t, restored.Restore(strings.NewReader(snap)))
+
+		deposit := newDeposit("0104", "1", "$5000.00", time.Date(2021, 1, 4, 10, 0, 0, 0, time.UTC))
+		assert.True(t, restored.HasBeenValidated(&deposit))
+
+		// The weekly limit of $20,000 is reached by the fourth deposit of the week
+		assert.Equal(t, []bool{true, true, false}, validateDeposits(restored, "1", 6, 7, 8))
+		assert.Equal(t, []bool{true, true, true, false}, validateDeposits(restored, "2", 5, 6, 7, 8))
+	})
+
+	t.Run("Restore should replace the existing state", func(t *testing.T) {
+		restored := NewValidator()
+		assert.Equal(t, []bool{true}, validateDeposits(restored, "3", 4))
+		assert.NoError(t, restored.Restore(strings.NewReader(snap)))
+
+		deposit := newDeposit("0104", "3", "$5000.00", time.Date(2021, 1, 4, 10, 0, 0, 0, time.UTC))
+		assert.False(t, restored.HasBeenValidated(&deposit))
+
+		usage, err := restored.Usage("3", time.Time{})
+		assert.NoError(t, err)
+		assert.Empty(t, usage)
+	})
+
+	t.Run("Snapshot should write the same state that was restored", func(t *testing.T) {
+		restored := NewValidator()
+		assert.NoError(t, restored.Restore(strings.NewReader(snap)))
+
+		var again bytes.Buffer
+		assert.NoError(t, restored.Snapshot(&again))
+		assert.Equal(t, snap, again.String())
+	})
+
+	t.Run("Restore should return an error for an unsupported version", func(t *testing.T) {
+		err := NewValidator().Restore(strings.NewReader(`{"version":3,"customers":[]}`))
+		assert.EqualError(t, err, "unsupported snapshot version 3")
+	})
+
+	t.Run("Restore should return an error for an invalid snapshot", func(t *testing.T) {
+		assert.Error(t, NewValidator().Restore(strings.NewReader(`{"version":`)))
+	})
+
+	t.Run("Restore should save the state of a DiskStore", func(t *testing.T) {
+		dir := t.TempDir()
+
+		s, err := OpenDiskStore(dir)
+		assert.NoError(t, err)
+		assert.NoError(t, NewValidatorWithStore(DefaultPolicy(), s).Restore(strings.NewReader(snap)))
+		assert.NoError(t, s.Close())
+
+		s, err = OpenDiskStore(dir)
+		assert.NoError(t, err)
+		defer s.Close()
+
+		assert.Equal(t, []bool{true, true, false}, validateDeposits(NewValidatorWithStore(DefaultPolicy(), s), "1", 6, 7, 8))
+	})
+
+	t.Run("Snapshot should return an error if the store does not support snapshots", func(t *testing.T) {
+		v := NewValidatorWithStore(DefaultPolicy(), struct{ Store }{NewMemoryStore()})
+		assert.Equal(t, ErrSnapshotUnsupported, v.Snapshot(&bytes.Buffer{}))
+		assert.Equal(t, ErrSnapshotUnsupported, v.Restore(strings.NewReader(snap)))
+	})
+}
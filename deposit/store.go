@@ -0,0 +1,395 @@
+package deposit
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A Store holds the state of a validator: the deposits each customer has made that have
+// been validated, and the customer's accounts. State is read and changed one customer at a
+// time, so that a customer's deposits can be checked and committed atomically.
+type Store interface {
+	// Update calls f with a transaction over the customer's state, saving the changes made
+	// in the transaction if f returns nil. Updates of the same customer are run one at a time.
+	Update(customerID string, f func(tx Tx) error) error
+
+	// View calls f with a read-only transaction over the customer's state
+	View(customerID string, f func(tx Tx) error) error
+
+	// ForgetValidated forgets every customer's deposits made before the time, so that they
+	// are no longer reported as validated, and returns how many were forgotten
+	ForgetValidated(before time.Time) (int, error)
+
+	// CountValidated returns the number of deposits remembered as validated
+	CountValidated() (int, error)
+
+	// Close saves any state that has not been saved and releases the store's resources
+	Close() error
+}
+
+// A Tx reads and changes the state of a single customer
+type Tx interface {
+	// Validated reports whether the deposit with the key has been validated. Keys without a
+	// customer are shared by every customer, and others must be the transaction's customer.
+	Validated(key DedupeKey) (bool, error)
+
+	// MarkValidated records that the deposit with the key, made at the time, has been
+	// validated, and reports whether it was not already. No other transaction can mark the
+	// same key in between.
+	MarkValidated(key DedupeKey, at time.Time) (bool, error)
+
+	// SaveDecision remembers the decision made for the validated deposit with the key, so
+	// that it can be given again if the deposit is repeated
+	SaveDecision(key DedupeKey, decision Decision) error
+
+	// Decision returns the decision saved for the deposit with the key, or nil if the store
+	// does not remember one
+	Decision(key DedupeKey) (*Decision, error)
+
+	// Forgotten returns the time of the latest deposit forgotten among those the key is
+	// checked against, or zero if none have been. A deposit made at or before it may have
+	// been validated and forgotten, so it cannot be told apart from a new one.
+	Forgotten(key DedupeKey) (time.Time, error)
+
+	// Account returns the customer's account in the currency, or a new empty account if
+	// they have none. Changes to the account are saved with SaveAccount.
+	Account(currency string) (*Account, error)
+
+	// Accounts returns all of the customer's accounts, ordered by currency
+	Accounts() ([]*Account, error)
+
+	// SaveAccount saves the history and latest deposit time of an account
+	SaveAccount(account *Account) error
+}
+
+// The number of shards customers are spread across
+const shardCount = 64
+
+// MemoryStore keeps a validator's state in memory. Customers are spread across shards that
+// each have their own lock, so that customers in different shards do not contend with each
+// other. Changes are made as soon as they happen rather than when an update completes.
+type MemoryStore struct {
+	shards [shardCount]shard
+
+	// The deposits whose keys leave out the customer are kept as if they were made by a
+	// customer without an ID, in a shard of their own. It is locked after any other shard,
+	// and nothing else is locked while holding it, so locking it cannot deadlock.
+	global shard
+}
+
+type shard struct {
+	mu        sync.Mutex
+	customers map[string]*customerState
+
+	// The number of deposits remembered by the customers in the shard
+	validated int
+
+	// If set, validated deposits are remembered by the filter instead of by each customer
+	filter *dedupeFilter
+
+	// The time of the latest deposit forgotten by the filter, which cannot tell customers
+	// apart
+	forgotten time.Time
+}
+
+// The state of a single customer
+type customerState struct {
+	// The time of each validated deposit, by ID, to prevent duplicates
+	validated map[string]time.Time
+
+	// The decision made for each validated deposit, by ID, to answer repeated deposits
+	decisions map[string]*Decision
+
+	// The time of the latest validated deposit that has been forgotten
+	forgotten time.Time
+
+	// The customer's account in each currency
+	accounts map[string]*Account
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{}
+
+	for _, shard := range s.allShards() {
+		shard.customers = make(map[string]*customerState)
+	}
+
+	return s
+}
+
+// allShards returns every shard, ending with the shard of keys without a customer
+func (s *MemoryStore) allShards() []*shard {
+	shards := make([]*shard, 0, shardCount+1)
+	for i := range s.shards {
+		shards = append(shards, &s.shards[i])
+	}
+
+	return append(shards, &s.global)
+}
+
+func (s *MemoryStore) Update(customerID string, f func(tx Tx) error) error {
+	shard := s.shardFor(customerID)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+
+	return f(&memoryTx{store: s, shard: shard, customerID: customerID})
+}
+
+func (s *MemoryStore) View(customerID string, f func(tx Tx) error) error {
+	return s.Update(customerID, f)
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// ForgetValidated forgets the deposits made before the time one shard at a time, so that
+// validation only waits for the shard being swept. Deposits remembered by a filter are
+// forgotten a day at a time, once the whole day is before the time.
+func (s *MemoryStore) ForgetValidated(before time.Time) (int, error) {
+	forgotten := 0
+
+	for _, shard := range s.allShards() {
+		shard.mu.Lock()
+		forgotten += shard.forget(before)
+		shard.mu.Unlock()
+	}
+
+	return forgotten, nil
+}
+
+func (s *MemoryStore) CountValidated() (int, error) {
+	count := 0
+
+	for _, shard := range s.allShards() {
+		shard.mu.Lock()
+		count += shard.validated
+		shard.mu.Unlock()
+	}
+
+	return count, nil
+}
+
+// shardFor returns the shard holding the customer's state
+func (s *MemoryStore) shardFor(customerID string) *shard {
+	if customerID == "" {
+		return &s.global
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(customerID))
+
+	return &s.shards[h.Sum32()%shardCount]
+}
+
+// forget forgets the deposits made before the time. The caller must hold the shard's lock.
+func (sh *shard) forget(before time.Time) int {
+	forgotten := 0
+
+	if sh.filter != nil {
+		var latest time.Time
+		forgotten, latest = sh.filter.forget(before)
+
+		if latest.After(sh.forgotten) {
+			sh.forgotten = latest
+		}
+	}
+
+	for customerID := range sh.customers {
+		forgotten += sh.forgetCustomer(customerID, before)
+	}
+
+	return forgotten
+}
+
+// forgetCustomer forgets the customer's deposits made before the time. The caller must hold
+// the shard's lock.
+func (sh *shard) forgetCustomer(customerID string, before time.Time) int {
+	state, ok := sh.customers[customerID]
+	if !ok {
+		return 0
+	}
+
+	forgotten := 0
+
+	for depositID, at := range state.validated {
+		if !at.IsZero() && at.Before(before) {
+			delete(state.validated, depositID)
+			delete(state.decisions, depositID)
+			forgotten++
+
+			if at.After(state.forgotten) {
+				state.forgotten = at
+			}
+		}
+	}
+
+	sh.validated -= forgotten
+	return forgotten
+}
+
+// A memoryTx is a transaction over a customer's state while their shard is locked
+type memoryTx struct {
+	store      *MemoryStore
+	shard      *shard
+	customerID string
+}
+
+// state returns the customer's state, creating it if necessary
+func (tx *memoryTx) state() *customerState {
+	return tx.shard.state(tx.customerID)
+}
+
+// state returns the state of the customer in the shard, creating it if necessary
+func (sh *shard) state(customerID string) *customerState {
+	state, ok := sh.customers[customerID]
+	if !ok {
+		state = &customerState{
+			validated: make(map[string]time.Time),
+			decisions: make(map[string]*Decision),
+			accounts:  make(map[string]*Account),
+		}
+		sh.customers[customerID] = state
+	}
+
+	return state
+}
+
+// keyShard returns the shard that remembers the key, locking it until unlock is called if
+// it is not the transaction's shard
+func (tx *memoryTx) keyShard(key DedupeKey) (sh *shard, unlock func()) {
+	if key.CustomerID != "" || tx.shard == &tx.store.global {
+		return tx.shard, func() {}
+	}
+
+	global := &tx.store.global
+	global.mu.Lock()
+	return global, global.mu.Unlock
+}
+
+func (tx *memoryTx) Validated(key DedupeKey) (bool, error) {
+	shard, unlock := tx.keyShard(key)
+	defer unlock()
+
+	return shard.validatedKey(key), nil
+}
+
+func (tx *memoryTx) MarkValidated(key DedupeKey, at time.Time) (bool, error) {
+	shard, unlock := tx.keyShard(key)
+	defer unlock()
+
+	if shard.validatedKey(key) {
+		return false, nil
+	}
+
+	if shard.filter != nil {
+		shard.filter.add(key, at)
+	} else {
+		shard.state(key.CustomerID).validated[key.DepositID] = at
+	}
+
+	shard.validated++
+	return true, nil
+}
+
+// validatedKey reports whether the shard remembers the key. The caller must hold the
+// shard's lock.
+func (sh *shard) validatedKey(key DedupeKey) bool {
+	if sh.filter != nil {
+		return sh.filter.contains(key)
+	}
+
+	state, ok := sh.customers[key.CustomerID]
+	if !ok {
+		return false
+	}
+
+	_, validated := state.validated[key.DepositID]
+	return validated
+}
+
+// SaveDecision does nothing if deposits are remembered by a filter, as it only remembers
+// that they were validated
+func (tx *memoryTx) SaveDecision(key DedupeKey, decision Decision) error {
+	shard, unlock := tx.keyShard(key)
+	defer unlock()
+
+	if shard.filter != nil || !shard.validatedKey(key) {
+		return nil
+	}
+
+	shard.state(key.CustomerID).decisions[key.DepositID] = &decision
+	return nil
+}
+
+func (tx *memoryTx) Decision(key DedupeKey) (*Decision, error) {
+	shard, unlock := tx.keyShard(key)
+	defer unlock()
+
+	state, ok := shard.customers[key.CustomerID]
+	if !ok {
+		return nil, nil
+	}
+
+	decision, ok := state.decisions[key.DepositID]
+	if !ok {
+		return nil, nil
+	}
+
+	// Return a copy so that the remembered decision cannot be changed
+	saved := *decision
+	return &saved, nil
+}
+
+// Forgotten returns the latest deposit forgotten by the key's customer, or by any customer
+// in the shard if deposits are remembered by a filter
+func (tx *memoryTx) Forgotten(key DedupeKey) (time.Time, error) {
+	shard, unlock := tx.keyShard(key)
+	defer unlock()
+
+	if shard.filter != nil {
+		return shard.forgotten, nil
+	}
+
+	state, ok := shard.customers[key.CustomerID]
+	if !ok {
+		return time.Time{}, nil
+	}
+
+	return state.forgotten, nil
+}
+
+func (tx *memoryTx) Account(currency string) (*Account, error) {
+	state := tx.state()
+
+	account, ok := state.accounts[currency]
+	if !ok {
+		account = &Account{CustomerID: tx.customerID, Currency: currency}
+		state.accounts[currency] = account
+	}
+
+	return account, nil
+}
+
+func (tx *memoryTx) Accounts() ([]*Account, error) {
+	state, ok := tx.shard.customers[tx.customerID]
+	if !ok {
+		return nil, nil
+	}
+
+	accounts := make([]*Account, 0, len(state.accounts))
+	for _, account := range state.accounts {
+		accounts = append(accounts, account)
+	}
+
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Currency < accounts[j].Currency })
+	return accounts, nil
+}
+
+// SaveAccount does nothing, as the account returned by Account is the one in the store
+func (tx *memoryTx) SaveAccount(account *Account) error {
+	return nil
+}
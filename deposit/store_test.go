@@ -0,0 +1,192 @@
+package deposit
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+
+	t.Run("Update should save the customer's state", func(t *testing.T) {
+		err := s.Update("1", func(tx Tx) error {
+			account, _ := tx.Account("USD")
+			account.Latest = time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC)
+			tx.Account("EUR")
+			_, err := tx.MarkValidated(DedupeKey{"1", "10"}, time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC))
+			return err
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("View should read the customer's state", func(t *testing.T) {
+		s.View("1", func(tx Tx) error {
+			validated, _ := tx.Validated(DedupeKey{"1", "10"})
+			assert.True(t, validated)
+
+			accounts, _ := tx.Accounts()
+			assert.Len(t, accounts, 2)
+			assert.Equal(t, "EUR", accounts[0].Currency)
+			assert.Equal(t, time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC), accounts[1].Latest)
+			return nil
+		})
+	})
+
+	t.Run("View should not find other customers' state", func(t *testing.T) {
+		s.View("2", func(tx Tx) error {
+			validated, _ := tx.Validated(DedupeKey{"2", "10"})
+			assert.False(t, validated)
+
+			accounts, _ := tx.Accounts()
+			assert.Empty(t, accounts)
+			return nil
+		})
+	})
+}
+
+// validateDeposits validates deposits of $5000.00 by a customer on each of the days
+func validateDeposits(v Validator, customerID string, days ...int) []bool {
+	var accepted []bool
+
+	for _, day := range days {
+		deposit := newDeposit(time.Date(2021, 1, day, 10, 0, 0, 0, time.UTC).Format("0102"), customerID, "$5000.00", time.Date(2021, 1, day, 10, 0, 0, 0, time.UTC))
+		accepted = append(accepted, v.Validate(&deposit).Accepted)
+	}
+
+	return accepted
+}
+
+func TestDiskStore(t *testing.T) {
+	dir := t.TempDir()
+
+	open := func(t *testing.T) (*DiskStore, Validator) {
+		s, err := OpenDiskStore(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return s, NewValidatorWithStore(DefaultPolicy(), s)
+	}
+
+	t.Run("DiskStore should keep the state when it is reopened", func(t *testing.T) {
+		s, v := open(t)
+		assert.Equal(t, []bool{true, true}, validateDeposits(v, "1", 4, 5))
+		assert.NoError(t, s.Close())
+
+		s, v = open(t)
+		defer s.Close()
+
+		// The weekly limit is reached with the deposits made before the store was reopened
+		assert.Equal(t, []bool{true, true, false}, validateDeposits(v, "1", 6, 7, 8))
+
+		deposit := newDeposit("0104", "1", "$1.00", time.Date(2021, 1, 4, 10, 0, 0, 0, time.UTC))
+		assert.True(t, v.HasBeenValidated(&deposit))
+	})
+
+	t.Run("DiskStore should replay the log if it was not closed", func(t *testing.T) {
+		s, v := open(t)
+		assert.Equal(t, []bool{true}, validateDeposits(v, "2", 4))
+		s.log.Close()
+
+		s, v = open(t)
+		defer s.Close()
+
+		deposit := newDeposit("0104", "2", "$1.00", time.Date(2021, 1, 4, 10, 0, 0, 0, time.UTC))
+		_, err := v.ValidateOnce(&deposit)
+		assert.Equal(t, ErrAlreadyProcessed, err)
+	})
+
+	t.Run("DiskStore should remove an incomplete record at the end of the log", func(t *testing.T) {
+		s, v := open(t)
+		assert.Equal(t, []bool{true}, validateDeposits(v, "3", 4))
+		s.log.Write([]byte(`{"seq":`))
+		s.log.Close()
+
+		s, v = open(t)
+		assert.Equal(t, []bool{true}, validateDeposits(v, "3", 5))
+		s.log.Close()
+
+		s, v = open(t)
+		defer s.Close()
+		assert.Equal(t, []bool{true, true, false}, validateDeposits(v, "3", 6, 7, 8))
+	})
+
+	t.Run("DiskStore should skip records that are already in the snapshot", func(t *testing.T) {
+		s, v := open(t)
+		assert.Equal(t, []bool{true}, validateDeposits(v, "4", 4))
+		log, _ := ioutil.ReadFile(filepath.Join(dir, logFileName))
+		assert.NoError(t, s.Snapshot())
+
+		// Act as if the process stopped before the log was emptied
+		assert.Equal(t, []bool{true}, validateDeposits(v, "4", 5))
+		s.log.Close()
+		newer, _ := ioutil.ReadFile(filepath.Join(dir, logFileName))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, logFileName), append(log, newer...), 0644))
+
+		s, err := OpenDiskStore(dir)
+		assert.NoError(t, err)
+		defer s.Close()
+
+		s.View("4", func(tx Tx) error {
+			account, _ := tx.Account("USD")
+			assert.Len(t, account.History, 2)
+			return nil
+		})
+	})
+
+	t.Run("DiskStore should keep deposits forgotten if it was not closed", func(t *testing.T) {
+		s, v := open(t)
+		assert.Equal(t, []bool{true, true}, validateDeposits(v, "6", 4, 5))
+
+		// Other customers' deposits on the 4th are forgotten too
+		forgotten, err := s.ForgetValidated(time.Date(2021, 1, 5, 0, 0, 0, 0, time.UTC))
+		assert.NoError(t, err)
+		assert.Equal(t, 5, forgotten)
+		s.log.Close()
+
+		s, v = open(t)
+		defer s.Close()
+
+		first := newDeposit("0104", "6", "$1.00", time.Date(2021, 1, 4, 10, 0, 0, 0, time.UTC))
+		second := newDeposit("0105", "6", "$1.00", time.Date(2021, 1, 5, 10, 0, 0, 0, time.UTC))
+		assert.False(t, v.HasBeenValidated(&first))
+		assert.True(t, v.HasBeenValidated(&second))
+
+		s.View("6", func(tx Tx) error {
+			at, err := tx.Forgotten(DedupeKey{CustomerID: "6", DepositID: "0104"})
+			assert.NoError(t, err)
+			assert.Equal(t, first.Time, at)
+			return nil
+		})
+	})
+
+	t.Run("OpenDiskStore should return an error for a corrupt log", func(t *testing.T) {
+		dir := t.TempDir()
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, logFileName), []byte("{\n{}\n"), 0644))
+
+		_, err := OpenDiskStore(dir)
+		assert.Error(t, err)
+	})
+
+	t.Run("OpenDiskStore should return an error for an unsupported snapshot", func(t *testing.T) {
+		dir := t.TempDir()
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, snapshotFileName), []byte(`{"version":99}`), 0644))
+
+		_, err := OpenDiskStore(dir)
+		assert.EqualError(t, err, "unsupported snapshot version 99")
+	})
+
+	t.Run("DiskStore should refuse updates once the log cannot be written", func(t *testing.T) {
+		s, v := open(t)
+		s.log.Close()
+
+		deposit := newDeposit("1", "5", "$1.00", time.Date(2021, 1, 4, 10, 0, 0, 0, time.UTC))
+		_, err := v.ValidateOnce(&deposit)
+		assert.Error(t, err)
+		assert.Equal(t, []Reason{StoreUnavailable}, v.Validate(&deposit).Reasons)
+	})
+}
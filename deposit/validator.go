@@ -1,116 +1,453 @@
 package deposit
 
-import "time"
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
 
-// Declare the velocity limits
-const dailyLimit = 5000
-const weeklyLimit = 20000
-const maxDailyDeposits = 3
+// ErrAlreadyProcessed is returned by ValidateOnce for deposits that have already been validated
+var ErrAlreadyProcessed = errors.New("deposit has already been processed")
 
+// A Validator is safe for concurrent use. Deposits by the same customer are validated one at
+// a time, while different customers' deposits can be validated in parallel.
 type Validator interface {
 	HasBeenValidated(deposit *Deposit) bool
-	Validate(deposit *Deposit) bool
+	Validate(deposit *Deposit) Decision
+	ValidateOnce(deposit *Deposit) (Decision, error)
+	SetOverrides(overrides Overrides) error
+	Usage(customerID string, at time.Time) ([]Usage, error)
+	Snapshot(w io.Writer) error
+	Restore(r io.Reader) error
+	DedupeStats() (DedupeStats, error)
 }
 
-// The dailyLedger is used to record and validate deposits on the current day
-type dailyLedger struct {
-	year     int
-	month    time.Month
-	day      int
-	deposits int
-	total    float64
+type validator struct {
+	// The velocity limits to enforce
+	policy Policy
+
+	// The rules each deposit must pass, in order
+	rules []Rule
+
+	// Holds the deposits that have been validated and the customers' accounts
+	store Store
+
+	// Guards the overrides and calendars, which can be replaced while deposits are validated
+	mu        sync.RWMutex
+	overrides Overrides
+
+	// The calendar of the policy and of customers with their own time zone or week start
+	calendar  calendar
+	calendars map[string]calendar
+
+	// Tracks the window of deposits remembered in order to detect duplicates
+	dedupe dedupeWindow
+
+	// Returns the current time, which deposits cannot be too far ahead of
+	now func() time.Time
 }
 
-// The weeklyLedger is used to record and validate deposits over the current week
-type weeklyLedger struct {
-	year  int
-	week  int
-	total float64
+func NewValidator() Validator {
+	return NewValidatorWithPolicy(DefaultPolicy())
 }
 
-type validator struct {
-	// Record all validated deposits to prevent duplicates
-	validatedDeposits map[string]bool
+// NewValidatorWithPolicy creates a validator that enforces the limits of the policy using
+// the rules it names. The policy should be checked with Validate beforehand, as this
+// panics if the policy names a rule that has not been registered.
+func NewValidatorWithPolicy(policy Policy) Validator {
+	return NewValidatorWithStore(policy, NewMemoryStore())
+}
 
-	// Keep daily and weekly ledgers for each customer
-	dailyLedgers  map[string]dailyLedger
-	weeklyLedgers map[string]weeklyLedger
+// NewValidatorWithStore creates a validator like NewValidatorWithPolicy that keeps its state
+// in the store, which may already hold the state of an earlier validator
+func NewValidatorWithStore(policy Policy, store Store) Validator {
+	rules, err := lookupRules(policy.ruleNames())
+	if err != nil {
+		panic(err)
+	}
+
+	return newValidator(policy, store, rules)
 }
 
-func NewValidator() Validator {
-	return &validator{
-		validatedDeposits: make(map[string]bool),
-		dailyLedgers:      make(map[string]dailyLedger),
-		weeklyLedgers:     make(map[string]weeklyLedger),
+// NewValidatorWithRules creates a validator that enforces the limits of the policy using
+// the given rules instead of those named by the policy. Like NewValidatorWithPolicy, this
+// panics if the policy is invalid.
+func NewValidatorWithRules(policy Policy, rules ...Rule) Validator {
+	return newValidator(policy, NewMemoryStore(), rules)
+}
+
+func newValidator(policy Policy, store Store, rules []Rule) *validator {
+	v := &validator{
+		policy: policy,
+		rules:  rules,
+		store:  store,
+		now:    time.Now,
+	}
+
+	if err := v.SetOverrides(policy.Overrides); err != nil {
+		panic(err)
 	}
+
+	return v
 }
 
-// HasBeenValidated returns whether or not the deposit has already been processed
+// HasBeenValidated returns whether or not the deposit has already been processed. Deposits
+// are reported as not processed if the store cannot be read.
 func (v *validator) HasBeenValidated(deposit *Deposit) bool {
-	return v.validatedDeposits[getUniqueIdentifier(deposit)]
+	var validated bool
+
+	v.store.View(deposit.CustomerID, func(tx Tx) (err error) {
+		validated, err = tx.Validated(v.policy.dedupeKey(deposit))
+		return err
+	})
+
+	return validated
+}
+
+// SetOverrides replaces the customer overrides, e.g. after the overrides file is edited
+func (v *validator) SetOverrides(overrides Overrides) error {
+	if err := v.policy.validateOverrides(overrides); err != nil {
+		return err
+	}
+
+	policyCalendar, customerCalendars, err := v.policy.calendars(overrides)
+	if err != nil {
+		return err
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.overrides = overrides
+	v.calendar = policyCalendar
+	v.calendars = customerCalendars
+	return nil
+}
+
+// Validate returns whether or not the deposit is valid, and if not the reasons why. The
+// deposit is rejected if the store fails, or with InvalidDeposit if its fields cannot be used.
+func (v *validator) Validate(deposit *Deposit) Decision {
+	var decision Decision
+
+	if err := v.check(deposit); err != nil {
+		decision = newDecision(deposit)
+		decision.reject(InvalidDeposit)
+		return decision
+	}
+
+	err := v.store.Update(deposit.CustomerID, func(tx Tx) (err error) {
+		// Record the deposit so it does not get processed twice
+		key := v.policy.dedupeKey(deposit)
+		marked, err := tx.MarkValidated(key, deposit.Time)
+		if err != nil {
+			return err
+		}
+
+		decision, err = v.validate(tx, deposit)
+		if err != nil || !marked {
+			return err
+		}
+
+		return tx.SaveDecision(key, decision)
+	})
+
+	if err != nil {
+		decision = newDecision(deposit)
+		decision.reject(StoreUnavailable)
+		return decision
+	}
+
+	v.advance(deposit.Time)
+	return decision
+}
+
+// ValidateOnce validates the deposit unless it has already been validated, in which case
+// it returns ErrAlreadyProcessed along with the decision first made for the deposit,
+// flagged as a replay, if the store remembers it. Unlike calling HasBeenValidated and then
+// Validate, no other call can validate the deposit in between. A ValidationError is
+// returned for deposits whose fields cannot be used, and errors from the store are returned.
+func (v *validator) ValidateOnce(deposit *Deposit) (Decision, error) {
+	var decision Decision
+
+	if err := v.check(deposit); err != nil {
+		return Decision{}, err
+	}
+
+	err := v.store.Update(deposit.CustomerID, func(tx Tx) error {
+		// Recording the deposit fails to mark it if it has already been validated
+		key := v.policy.dedupeKey(deposit)
+		marked, err := tx.MarkValidated(key, deposit.Time)
+		if err != nil {
+			return err
+		}
+
+		if !marked {
+			return v.replay(tx, key, &decision)
+		}
+
+		decision, err = v.validate(tx, deposit)
+		if err != nil {
+			return err
+		}
+
+		return tx.SaveDecision(key, decision)
+	})
+
+	if err == nil {
+		v.advance(deposit.Time)
+	}
+
+	return decision, err
+}
+
+// replay sets the decision to the one saved for the key, if there is one, and returns
+// ErrAlreadyProcessed
+func (v *validator) replay(tx Tx, key DedupeKey, decision *Decision) error {
+	original, err := tx.Decision(key)
+	if err != nil {
+		return err
+	}
+
+	if original != nil {
+		*decision = *original
+		decision.Replay = true
+	}
+
+	return ErrAlreadyProcessed
+}
+
+// check checks the fields of the deposit before anything is recorded about it
+func (v *validator) check(deposit *Deposit) error {
+	return deposit.Check(v.now(), v.policy.ClockSkew)
 }
 
-// Validate returns whether or not the deposit is valid
-func (v *validator) Validate(deposit *Deposit) bool {
+// validate validates the deposit in a transaction over the customer's state, once the
+// deposit has been recorded as validated
+func (v *validator) validate(tx Tx, deposit *Deposit) (Decision, error) {
 	err := deposit.parseAmount()
+	decision := newDecision(deposit)
+
+	if err != nil {
+		decision.reject(reasonForError(err))
+		return decision, nil
+	}
+
+	// Deposits that have been forgotten could be validated again. Only the deposits the key
+	// is checked against count, so that other customers' deposits cannot make this one late.
+	forgotten, err := tx.Forgotten(v.policy.dedupeKey(deposit))
+	if err != nil {
+		return Decision{}, err
+	}
+
+	if !forgotten.IsZero() && !deposit.Time.After(forgotten) {
+		decision.reject(DepositTooLate)
+		return decision, nil
+	}
+
+	overrides, c := v.settingsFor(deposit.CustomerID)
+
+	// Deposits are rejected in currencies that have no limits configured
+	limits, ok := v.policy.limitsFor(overrides, deposit.CustomerID, deposit.Currency)
+	if !ok {
+		decision.reject(UnsupportedCurrency)
+		return decision, nil
+	}
+
+	account, err := loadAccount(tx, deposit.Currency, limits)
+	if err != nil {
+		return Decision{}, err
+	}
 
-	// Record the deposit so it does not get processed twice
-	v.validatedDeposits[getUniqueIdentifier(deposit)] = true
+	loads := []Load{{deposit, deposit.ParsedAmount}}
+	accounts := []*Account{account}
 
-	if err == nil && v.validateDailyLimits(deposit) && v.validateWeeklyLimit(deposit) {
-		// Record the deposit in the daily ledger
-		dailyLedger := v.dailyLedgers[deposit.CustomerID]
-		dailyLedger.deposits++
-		dailyLedger.total += deposit.ParsedAmount
-		v.dailyLedgers[deposit.CustomerID] = dailyLedger
+	if v.policy.BaseCurrency != "" {
+		conversion, err := v.convert(deposit)
+		if err != nil {
+			decision.reject(reasonForError(err))
+			return decision, nil
+		}
 
-		// Record the deposit in the weekly ledger
-		weeklyLedger := v.weeklyLedgers[deposit.CustomerID]
-		weeklyLedger.total += deposit.ParsedAmount
-		v.weeklyLedgers[deposit.CustomerID] = weeklyLedger
+		baseLimits, _ := v.policy.limitsFor(overrides, deposit.CustomerID, v.policy.BaseCurrency)
+		baseAccount, err := loadAccount(tx, "", baseLimits)
+		if err != nil {
+			return Decision{}, err
+		}
 
-		return true
+		decision.Conversion = conversion
+		loads = append(loads, Load{deposit, conversion.Amount})
+		accounts = append(accounts, baseAccount)
 	}
 
-	return false
+	// The deposit is rejected if it is too late to be checked against the earlier deposits
+	for _, account := range accounts {
+		if deposit.Time.Before(account.Latest.Add(-v.policy.Lateness)) {
+			decision.reject(DepositTooLate)
+			return decision, nil
+		}
+	}
+
+	// Run every rule so that all of the reasons for a rejection are reported
+	for i, account := range accounts {
+		account.prepare(deposit.Time, c)
+
+		for _, rule := range v.rules {
+			if reason := rule.Evaluate(loads[i], account); reason != "" {
+				decision.reject(reason)
+			}
+		}
+	}
+
+	// Only accepted deposits move the accounts on, so that a declined deposit dated in the
+	// future cannot make the customer's later deposits late
+	if len(decision.Reasons) > 0 {
+		return decision, nil
+	}
+
+	for i, account := range accounts {
+		if deposit.Time.After(account.Latest) {
+			account.Latest = deposit.Time
+		}
+
+		for _, rule := range v.rules {
+			rule.Commit(loads[i], account)
+		}
+
+		account.record(loads[i])
+		account.prune(v.retainFrom(account, c))
+	}
+
+	decision.Accepted = true
+	return decision, saveAccounts(tx, accounts)
 }
 
-func getUniqueIdentifier(deposit *Deposit) string {
-	return deposit.ID + "-" + deposit.CustomerID
+// Usage reports the customer's use of their limits in each of their accounts, ordered by
+// currency with the combined account last. The periods reported are those containing the
+// given time, or the latest deposit into each account if the time is zero.
+func (v *validator) Usage(customerID string, at time.Time) ([]Usage, error) {
+	var usage []Usage
+	overrides, c := v.settingsFor(customerID)
+
+	err := v.store.View(customerID, func(tx Tx) error {
+		accounts, err := tx.Accounts()
+		if err != nil {
+			return err
+		}
+
+		for _, account := range accounts {
+			t := at
+			if t.IsZero() {
+				t = account.Latest
+			}
+
+			// The ledgers are filled in on a copy so the account is left as it was
+			a := *account
+			a.prepare(t, c)
+
+			u := Usage{Currency: a.Currency, Daily: a.Daily, Weekly: a.Weekly, Monthly: a.Monthly}
+			if u.Currency == "" {
+				u.Currency = v.policy.BaseCurrency
+				u.Combined = true
+			}
+
+			u.Limits, _ = v.policy.limitsFor(overrides, customerID, u.Currency)
+			usage = append(usage, u)
+		}
+
+		return nil
+	})
+
+	// The combined account has an empty currency, so it is first rather than last
+	if len(usage) > 0 && usage[0].Combined {
+		usage = append(usage[1:], usage[0])
+	}
+
+	return usage, err
+}
+
+// Snapshot writes the deposits that have been validated and the customers' accounts to w,
+// so they can be restored into another validator. It returns ErrSnapshotUnsupported if the
+// store is not a Snapshotter.
+func (v *validator) Snapshot(w io.Writer) error {
+	snapshotter, ok := v.store.(Snapshotter)
+	if !ok {
+		return ErrSnapshotUnsupported
+	}
+
+	return snapshotter.WriteSnapshot(w)
+}
+
+// Restore replaces the validator's state with a snapshot written by Snapshot. It returns
+// ErrSnapshotUnsupported if the store is not a Snapshotter.
+func (v *validator) Restore(r io.Reader) error {
+	snapshotter, ok := v.store.(Snapshotter)
+	if !ok {
+		return ErrSnapshotUnsupported
+	}
+
+	return snapshotter.ReadSnapshot(r)
 }
 
-func (v *validator) validateDailyLimits(deposit *Deposit) bool {
-	// Get the customer's ledger, or an empty ledger if their ledger didn't exist
-	ledger := v.dailyLedgers[deposit.CustomerID]
+// retainFrom returns the time of the earliest deposit that can still affect a decision,
+// since the latest deposits can be followed by others as late as the policy allows
+func (v *validator) retainFrom(account *Account, c calendar) time.Time {
+	earliest := account.Latest.Add(-v.policy.Lateness)
 
-	year, month, day := deposit.Time.Date()
+	// Keep every deposit in the week and month of the earliest deposit, or in its rolling
+	// windows
+	from := c.startOfMonth(earliest)
+	for _, start := range []time.Time{c.startOfWeek(earliest), earliest.Add(-account.Limits.longestWindow())} {
+		if start.Before(from) {
+			from = start
+		}
+	}
 
-	// Update the current date and clear the ledger if the deposit occurs on a new day
-	if ledger.year != year || ledger.month != month || ledger.day != day {
-		ledger.year = year
-		ledger.month = month
-		ledger.day = day
-		ledger.deposits = 0
-		ledger.total = 0
-		v.dailyLedgers[deposit.CustomerID] = ledger
+	return from
+}
+
+// settingsFor returns the overrides, and the calendar used for the customer's ledgers
+func (v *validator) settingsFor(customerID string) (Overrides, calendar) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if c, ok := v.calendars[customerID]; ok {
+		return v.overrides, c
 	}
 
-	return ledger.deposits < maxDailyDeposits && (ledger.total+deposit.ParsedAmount) <= dailyLimit
+	return v.overrides, v.calendar
 }
 
-func (v *validator) validateWeeklyLimit(deposit *Deposit) bool {
-	// Get the customer's ledger, or an empty ledger if their ledger didn't exist
-	ledger := v.weeklyLedgers[deposit.CustomerID]
+// loadAccount returns the customer's account in the currency with the limits that apply to it
+func loadAccount(tx Tx, currency string, limits Limits) (*Account, error) {
+	account, err := tx.Account(currency)
+	if err != nil {
+		return nil, err
+	}
 
-	year, week := deposit.Time.ISOWeek()
+	// Limits are resolved for every deposit in case the customer's overrides changed
+	account.Limits = limits
+
+	return account, nil
+}
+
+// saveAccounts saves the accounts changed by a deposit
+func saveAccounts(tx Tx, accounts []*Account) error {
+	for _, account := range accounts {
+		if err := tx.SaveAccount(account); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
 
-	// Update the current week and clear the ledger if the deposit occurs in a new week
-	if ledger.year != year || ledger.week != week {
-		ledger.year = year
-		ledger.week = week
-		ledger.total = 0
-		v.weeklyLedgers[deposit.CustomerID] = ledger
+// convert converts the deposit amount into the base currency
+func (v *validator) convert(deposit *Deposit) (*Conversion, error) {
+	rate, err := v.policy.Rates.Rate(deposit.Currency, v.policy.BaseCurrency, deposit.Time)
+	if err != nil {
+		return nil, err
 	}
 
-	return (ledger.total + deposit.ParsedAmount) <= weeklyLimit
+	return &Conversion{Currency: v.policy.BaseCurrency, Amount: rate.Convert(deposit.ParsedAmount), Rate: rate}, nil
 }
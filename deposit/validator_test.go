@@ -0,0 +1,151 @@
+package deposit
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// hammer calls f from many goroutines at once and waits for them to finish
+func hammer(goroutines int, f func(i int)) {
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			f(i)
+		}(i)
+	}
+
+	close(start)
+	wg.Wait()
+}
+
+func TestValidateOnce(t *testing.T) {
+	v := NewValidator()
+
+	t.Run("ValidateOnce should validate new deposits", func(t *testing.T) {
+		deposit := newDeposit("1", "1", "$1.00", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC))
+		decision, err := v.ValidateOnce(&deposit)
+		assert.NoError(t, err)
+		assert.True(t, decision.Accepted)
+	})
+
+	t.Run("ValidateOnce should return an error for deposits that have been validated", func(t *testing.T) {
+		deposit := newDeposit("1", "1", "$1.00", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC))
+		_, err := v.ValidateOnce(&deposit)
+		assert.Equal(t, ErrAlreadyProcessed, err)
+	})
+}
+
+func TestValidator_Concurrency(t *testing.T) {
+	t.Run("ValidateOnce should validate a deposit sent by many callers exactly once", func(t *testing.T) {
+		v := NewValidator()
+		var mu sync.Mutex
+		validated := 0
+
+		hammer(100, func(i int) {
+			deposit := newDeposit("1", "1", "$1.00", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC))
+			if _, err := v.ValidateOnce(&deposit); err == nil {
+				mu.Lock()
+				validated++
+				mu.Unlock()
+			}
+		})
+
+		assert.Equal(t, 1, validated)
+	})
+
+	t.Run("Validate should enforce a customer's limits across concurrent deposits", func(t *testing.T) {
+		v := NewValidator()
+		var mu sync.Mutex
+		accepted := 0
+
+		hammer(100, func(i int) {
+			deposit := newDeposit(fmt.Sprint(i), "1", "$1.00", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC))
+			if v.Validate(&deposit).Accepted {
+				mu.Lock()
+				accepted++
+				mu.Unlock()
+			}
+		})
+
+		assert.Equal(t, 3, accepted)
+	})
+
+	t.Run("Validate should keep every customer's deposits separate", func(t *testing.T) {
+		v := NewValidator()
+		customers := 50
+		accepted := make([]int, customers)
+
+		// Each goroutine makes a customer's deposits in order, while other goroutines
+		// deposit for the other customers and read their usage
+		hammer(customers*2, func(i int) {
+			customerID := fmt.Sprint(i % customers)
+
+			if i >= customers {
+				for j := 0; j < 10; j++ {
+					v.Usage(customerID, time.Time{})
+					v.HasBeenValidated(&Deposit{ID: fmt.Sprint(j), CustomerID: customerID})
+				}
+				return
+			}
+
+			for j := 0; j < 10; j++ {
+				deposit := newDeposit(fmt.Sprint(j), customerID, "$5000.00", time.Date(2021, 1, 4+j, 10, 0, 0, 0, time.UTC))
+				if v.Validate(&deposit).Accepted {
+					accepted[i]++
+				}
+			}
+		})
+
+		// The weekly limit allows four of the deposits in the first week and three in the next
+		for i := range accepted {
+			assert.Equal(t, 7, accepted[i], "customer %d", i)
+		}
+	})
+
+	t.Run("SetOverrides should be safe while deposits are validated", func(t *testing.T) {
+		v := NewValidatorWithPolicy(tieredPolicy())
+
+		hammer(20, func(i int) {
+			if i%2 == 0 {
+				v.SetOverrides(Overrides{Customers: map[string]CustomerOverride{fmt.Sprint(i): {Tier: "premium"}}})
+				return
+			}
+
+			deposit := newDeposit("1", fmt.Sprint(i), "$1.00", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC))
+			assert.True(t, v.Validate(&deposit).Accepted)
+		})
+	})
+}
+
+func TestValidator_InvalidDeposit(t *testing.T) {
+	v := newValidator(DefaultPolicy(), NewMemoryStore(), nil)
+	v.now = func() time.Time { return time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC) }
+
+	t.Run("Validate should reject deposits with invalid fields", func(t *testing.T) {
+		deposit := newDeposit("1", "", "$1.00", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC))
+		assert.Equal(t, Decision{ID: "1", Reasons: []Reason{InvalidDeposit}}, v.Validate(&deposit))
+	})
+
+	t.Run("ValidateOnce should return a ValidationError for deposits with invalid fields", func(t *testing.T) {
+		deposit := newDeposit("1", "1", "$1.00", time.Date(2021, 1, 10, 10, 0, 0, 0, time.UTC))
+		_, err := v.ValidateOnce(&deposit)
+		assert.True(t, errors.Is(err, ErrTooFarInFuture))
+
+		// Nothing is recorded about the deposit
+		assert.False(t, v.HasBeenValidated(&deposit))
+		deposit.Time = time.Date(2021, 1, 9, 9, 0, 0, 0, time.UTC)
+		decision, err := v.ValidateOnce(&deposit)
+		assert.NoError(t, err)
+		assert.True(t, decision.Accepted)
+	})
+}
@@ -2,51 +2,368 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"runtime"
+	"strings"
+	"time"
 
 	"github.com/travisbale/deposit-validator/deposit"
 )
 
+// An errorRecord reports an input line that could not be processed. The file is left out
+// for lines read from stdin, and the invalid fields are only given for deposits that were
+// read but whose fields cannot be used.
+type errorRecord struct {
+	File   string            `json:"file,omitempty"`
+	Line   int               `json:"line"`
+	Error  string            `json:"error"`
+	Fields map[string]string `json:"invalid_fields,omitempty"`
+}
+
+// options control how the input is processed
+type options struct {
+	// Include the reasons deposits were rejected in the output
+	showReasons bool
+
+	// Stop at the first line that cannot be processed instead of reporting it
+	strict bool
+
+	// Only accept deposits that follow the published schema exactly
+	strictJSON bool
+
+	// How deposits that have already been processed are answered, skipping them by default
+	duplicates deposit.ReplayMode
+
+	// The number of customers whose deposits are validated in parallel
+	workers int
+
+	// The scope load IDs are unique in, as deposits are only validated in parallel when
+	// each customer's IDs are their own
+	dedupeScope deposit.DedupeScope
+
+	// How long validated deposits are remembered, as deposits are only validated in parallel
+	// when they are never forgotten
+	dedupeWindow time.Duration
+}
+
 func main() {
-	// Open the input file for reading
-	inFile, err := os.Open("input.txt")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "serve":
+			checkError(serve(os.Args[2:]))
+			return
+		case "serve-grpc":
+			checkError(serveGRPC(os.Args[2:]))
+			return
+		}
+	}
+
+	var outputPath string
+	flag.StringVar(&outputPath, "output", "-", "path to write the responses to, or - for stdout")
+	flag.StringVar(&outputPath, "o", "-", "shorthand for -output")
+	policyFile := flag.String("policy", "", "path to a YAML or JSON file of velocity limits")
+	showReasons := flag.Bool("reasons", false, "include the reasons deposits were rejected in the output")
+	errorsFile := flag.String("errors", "", "path to write malformed lines to instead of the output")
+	strict := flag.Bool("strict", false, "exit on the first malformed line")
+	strictJSON := flag.Bool("strict-json", false, "treat deposits with unknown, repeated or null fields as malformed instead of ignoring the fields")
+	duplicates := flag.String("duplicates", string(deposit.ReplaySkip), "how to answer deposits that have already been processed: echo the original decision, skip them, or write an error")
+	dataDir := flag.String("data", "", "directory to keep the deposits validated in, so they are remembered by the next run")
+	filterDeposits := flag.Int("filter", 0, "remember validated deposits in bloom filters sized for this many deposits a day, instead of by ID")
+	workers := flag.Int("workers", runtime.NumCPU(), "number of deposits to validate in parallel")
+	restoreFile := flag.String("restore", "", "path to a snapshot of the state to start from")
+	checkpointFile := flag.String("checkpoint", "", "path to save a snapshot of the state to after each input")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [input ...]\n       %s serve [flags]\n       %s serve-grpc [flags]\n\nReads deposits from each input in turn, or from stdin if none are given or the input is -\n\n", os.Args[0], os.Args[0], os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	policy, err := loadPolicy(*policyFile)
+	checkError(err)
+
+	replayMode, err := deposit.ParseReplayMode(*duplicates)
 	checkError(err)
-	defer inFile.Close()
 
-	// Open the output file for writing
-	outFile, err := os.Create("output.txt")
+	// Open the output for writing
+	outFile, err := createOutput(outputPath)
 	checkError(err)
 	defer outFile.Close()
 
-	scanner := bufio.NewScanner(inFile)
-	depositValidator := deposit.NewValidator()
+	// Malformed lines are reported in the output unless they have a file of their own
+	var errOut io.Writer = outFile
+	if *errorsFile != "" {
+		errFile, err := createOutput(*errorsFile)
+		checkError(err)
+		defer errFile.Close()
+		errOut = errFile
+	}
+
+	inputs := flag.Args()
+	if len(inputs) == 0 {
+		inputs = []string{"-"}
+	}
+
+	store, err := openStore(*dataDir, *filterDeposits)
+	checkError(err)
+
+	// Every input is checked by the same validator, so limits apply across all of them
+	depositValidator := deposit.NewValidatorWithStore(policy, store)
+	opts := options{showReasons: *showReasons, strict: *strict, strictJSON: *strictJSON, duplicates: replayMode, workers: *workers, dedupeScope: policy.DedupeScope, dedupeWindow: policy.DedupeWindow}
+
+	if *restoreFile != "" {
+		checkError(restoreSnapshot(depositValidator, *restoreFile))
+	}
 
-	// Scan the input file line by line
-	for scanner.Scan() {
-		response, err := processInput(depositValidator, scanner.Text())
+	for _, path := range inputs {
+		checkError(processFile(depositValidator, path, outFile, errOut, opts))
 
-		if err == nil {
-			_, err := outFile.WriteString(response + "\n")
-			checkError(err)
+		if *checkpointFile != "" {
+			checkError(saveSnapshot(depositValidator, *checkpointFile))
 		}
 	}
+
+	checkError(store.Close())
 }
 
-func processInput(depositValidator deposit.Validator, input string) (string, error) {
-	deposit, err := deposit.ParseJson(input)
-	checkError(err)
+// loadPolicy loads the policy file at the path, or the default limits if there is none
+func loadPolicy(path string) (deposit.Policy, error) {
+	if path == "" {
+		return deposit.DefaultPolicy(), nil
+	}
+
+	return deposit.LoadPolicy(path)
+}
+
+// The rate at which a filter sized for the deposits made in a day mistakes an unseen
+// deposit for one that has been validated
+const filterFalsePositiveRate = 0.0001
+
+// openStore opens the store saved in the data directory, or an empty in-memory store if
+// there is none. The in-memory store remembers validated deposits in filters sized for the
+// number of deposits a day, if one is given.
+func openStore(dataDir string, filterDeposits int) (deposit.Store, error) {
+	if filterDeposits > 0 {
+		if dataDir != "" {
+			return nil, errors.New("validated deposits cannot be saved to disk when they are kept in filters")
+		}
+
+		return deposit.NewMemoryStoreWithFilter(filterDeposits, filterFalsePositiveRate)
+	}
+
+	if dataDir == "" {
+		return deposit.NewMemoryStore(), nil
+	}
+
+	return deposit.OpenDiskStore(dataDir)
+}
+
+// restoreSnapshot replaces the validator's state with the snapshot at the path
+func restoreSnapshot(depositValidator deposit.Validator, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := depositValidator.Restore(bufio.NewReader(file)); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	return nil
+}
+
+// saveSnapshot saves a snapshot of the validator's state to the path. The snapshot is
+// written to a temporary file first so the previous one is kept if this fails.
+func saveSnapshot(depositValidator deposit.Validator, path string) error {
+	tmp := path + ".tmp"
+
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(file)
+	err = depositValidator.Snapshot(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// processFile processes the lines of the input at the path, where - is stdin
+func processFile(depositValidator deposit.Validator, path string, out io.Writer, errOut io.Writer, opts options) error {
+	if path == "-" {
+		return processLines(depositValidator, "", os.Stdin, out, errOut, opts)
+	}
+
+	inFile, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer inFile.Close()
+
+	return processLines(depositValidator, path, inFile, out, errOut, opts)
+}
+
+// createOutput creates the file at the path for writing, where - is stdout
+func createOutput(path string) (io.WriteCloser, error) {
+	if path == "-" {
+		return nopCloser{os.Stdout}, nil
+	}
+
+	return os.Create(path)
+}
+
+// nopCloser keeps stdout open when the output is closed
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// processLines validates each line of the named input and writes the responses to out.
+// Lines that cannot be processed are reported to errOut, or stop processing in strict mode.
+func processLines(depositValidator deposit.Validator, name string, in io.Reader, out io.Writer, errOut io.Writer, opts options) error {
+	w := resultWriter{name: name, out: out, errOut: errOut, strict: opts.strict, skipDuplicates: opts.skipDuplicates()}
+
+	// Workers are assigned customers, so when IDs are shared by every customer the first
+	// line with an ID could lose the race to a later one on another worker. Deposits are
+	// forgotten as the latest deposit by any customer moves on, so a worker that runs ahead
+	// could also make another worker's customers forget deposits too soon.
+	if opts.workers > 1 && opts.dedupeScope != deposit.GlobalScope && opts.dedupeWindow == 0 {
+		return processLinesParallel(depositValidator, in, w, opts)
+	}
+
+	scanner := bufio.NewScanner(in)
+
+	// Scan the input line by line
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		response, err := processInput(depositValidator, scanner.Text(), opts)
+		if err := w.write(line, response, err); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+func processInput(depositValidator deposit.Validator, input string, opts options) (string, error) {
+	deposit, err := opts.parse(input)
+	if err != nil {
+		return "", err
+	}
+
+	return validateDeposit(depositValidator, deposit, opts)
+}
+
+// parse parses a line of input into a deposit
+func (opts options) parse(input string) (*deposit.Deposit, error) {
+	if opts.strictJSON {
+		return deposit.ParseJsonStrict(input)
+	}
+
+	return deposit.ParseJson(input)
+}
+
+// skipDuplicates reports whether deposits that have already been processed are left out of
+// the output
+func (opts options) skipDuplicates() bool {
+	return opts.duplicates != deposit.ReplayEcho && opts.duplicates != deposit.ReplayError
+}
+
+// validateDeposit validates a parsed deposit and returns the response. Deposits that have
+// already been validated are answered with the original decision in echo mode, as long as
+// the store remembers it.
+func validateDeposit(depositValidator deposit.Validator, d *deposit.Deposit, opts options) (string, error) {
+	decision, err := depositValidator.ValidateOnce(d)
+	if err == deposit.ErrAlreadyProcessed && opts.duplicates == deposit.ReplayEcho && decision.Replay {
+		err = nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	// Reasons are left out of the response unless they were asked for
+	if !opts.showReasons {
+		decision.Reasons = nil
+	}
+
+	result, err := json.Marshal(decision)
+	return string(result), err
+}
+
+// A resultWriter writes the response to each line of a named input
+type resultWriter struct {
+	name           string
+	out            io.Writer
+	errOut         io.Writer
+	strict         bool
+	skipDuplicates bool
+}
+
+// write writes the response to a line, or an error record if the line could not be
+// processed. Duplicate deposits may be skipped, and in strict mode any other error is
+// returned.
+func (w resultWriter) write(line int, response string, err error) error {
+	out := w.out
+
+	switch {
+	case err == deposit.ErrAlreadyProcessed && w.skipDuplicates:
+		return nil
+	case err != nil && w.strict && w.name != "":
+		return fmt.Errorf("%s line %d: %w", w.name, line, err)
+	case err != nil && w.strict:
+		return fmt.Errorf("line %d: %w", line, err)
+	case err != nil:
+		record, _ := json.Marshal(errorRecord{File: w.name, Line: line, Error: err.Error(), Fields: invalidFields(err)})
+		response = string(record)
+		out = w.errOut
+	}
+
+	_, err = io.WriteString(out, response+"\n")
+	return err
+}
+
+// stops reports whether the error of a line stops processing, as any error but that of a
+// skipped duplicate does in strict mode
+func (w resultWriter) stops(err error) bool {
+	return w.strict && err != nil && !(err == deposit.ErrAlreadyProcessed && w.skipDuplicates)
+}
 
-	// Ignore the deposit if it has already been validated
-	if !depositValidator.HasBeenValidated(deposit) {
-		isValid := depositValidator.Validate(deposit)
-		result := fmt.Sprintf(`{"id":"%s","customer_id":"%s","accepted":%t}`, deposit.ID, deposit.CustomerID, isValid)
-		return result, nil
+// invalidFields returns the problem with each invalid field of a deposit, if the error is a
+// deposit.ValidationError
+func invalidFields(err error) map[string]string {
+	var invalid *deposit.ValidationError
+	if !errors.As(err, &invalid) {
+		return nil
 	}
 
-	return "", errors.New("deposit has already been processed")
+	return invalid.Problems()
 }
 
 func checkError(err error) {
@@ -1,6 +1,11 @@
 package main
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -12,15 +17,242 @@ func TestProcessInput(t *testing.T) {
 
 	t.Run("processInput should return properly formatted JSON", func(t *testing.T) {
 		input := `{"id":"15887","customer_id":"528","load_amount":"$3318.47","time":"2000-01-01T00:00:00Z"}`
-		result, _ := processInput(validator, input)
+		result, _ := processInput(validator, input, options{})
 
 		assert.Equal(t, result, `{"id":"15887","customer_id":"528","accepted":true}`)
 	})
 
 	t.Run("prcessInput should return an error if the deposit has been validated", func(t *testing.T) {
 		input := `{"id":"15887","customer_id":"528","load_amount":"$3318.47","time":"2000-01-01T00:00:00Z"}`
-		_, err := processInput(validator, input)
+		_, err := processInput(validator, input, options{})
 
-		assert.EqualError(t, err, "Deposit has already been processed")
+		assert.EqualError(t, err, "deposit has already been processed")
+	})
+
+	t.Run("processInput should include the reasons for a rejection if asked", func(t *testing.T) {
+		input := `{"id":"15888","customer_id":"528","load_amount":"$3318.47","time":"2000-01-01T01:00:00Z"}`
+		result, _ := processInput(validator, input, options{showReasons: true})
+
+		assert.Equal(t, result, `{"id":"15888","customer_id":"528","accepted":false,"reasons":["DAILY_AMOUNT_EXCEEDED"]}`)
+	})
+
+	t.Run("processInput should leave out the reasons unless asked", func(t *testing.T) {
+		input := `{"id":"15889","customer_id":"528","load_amount":"$3318.47","time":"2000-01-01T02:00:00Z"}`
+		result, _ := processInput(validator, input, options{})
+
+		assert.Equal(t, result, `{"id":"15889","customer_id":"528","accepted":false}`)
+	})
+}
+
+func TestProcessInput_Conversion(t *testing.T) {
+	rates := deposit.NewStaticRates()
+	rate, _ := deposit.ParseRate("1.25")
+	rates.Add("EUR", "USD", rate)
+
+	policy := deposit.DefaultPolicy()
+	policy.BaseCurrency = "USD"
+	policy.Rates = rates
+	validator := deposit.NewValidatorWithPolicy(policy)
+
+	t.Run("processInput should include the exchange rate used", func(t *testing.T) {
+		input := `{"id":"1","customer_id":"1","load_amount":"€100.00","time":"2000-01-01T00:00:00Z"}`
+		result, _ := processInput(validator, input, options{})
+
+		assert.Equal(t, result, `{"id":"1","customer_id":"1","accepted":true,"conversion":{"currency":"USD","amount":"$125.00","rate":"1.25"}}`)
+	})
+}
+
+func TestProcessLines(t *testing.T) {
+	input := strings.Join([]string{
+		`{"id":"1","customer_id":"1","load_amount":"$1.00","time":"2000-01-01T00:00:00Z"}`,
+		`{"id":"2","customer_id":"1","load_amount":"$1.00"`,
+		`{"id":"3","customer_id":"1","time":"2000-01-01T01:00:00Z"}`,
+		``,
+		`{"id":"4","customer_id":"1","load_amount":"$1.00","time":"2000-01-01T02:00:00Z"}`,
+		`{"id":"4","customer_id":"1","load_amount":"$1.00","time":"2000-01-01T02:00:00Z"}`,
+	}, "\n")
+
+	t.Run("processLines should report malformed lines and continue", func(t *testing.T) {
+		var out bytes.Buffer
+		err := processLines(deposit.NewValidator(), "", strings.NewReader(input), &out, &out, options{})
+
+		assert.NoError(t, err)
+		assert.Equal(t, strings.Join([]string{
+			`{"id":"1","customer_id":"1","accepted":true}`,
+			`{"line":2,"error":"unexpected end of JSON input"}`,
+			`{"line":3,"error":"missing load_amount"}`,
+			`{"id":"4","customer_id":"1","accepted":true}`,
+		}, "\n")+"\n", out.String())
+	})
+
+	t.Run("processLines should write malformed lines to a separate writer", func(t *testing.T) {
+		var out, errOut bytes.Buffer
+		err := processLines(deposit.NewValidator(), "", strings.NewReader(input), &out, &errOut, options{})
+
+		assert.NoError(t, err)
+		assert.Equal(t, 2, strings.Count(out.String(), "\n"))
+		assert.Equal(t, `{"line":2,"error":"unexpected end of JSON input"}`+"\n"+`{"line":3,"error":"missing load_amount"}`+"\n", errOut.String())
+	})
+
+	t.Run("processLines should stop at the first malformed line in strict mode", func(t *testing.T) {
+		var out bytes.Buffer
+		err := processLines(deposit.NewValidator(), "", strings.NewReader(input), &out, &out, options{strict: true})
+
+		assert.EqualError(t, err, "line 2: unexpected end of JSON input")
+		assert.Equal(t, `{"id":"1","customer_id":"1","accepted":true}`+"\n", out.String())
+	})
+
+	t.Run("processLines should report unknown fields with strict JSON", func(t *testing.T) {
+		input := `{"id":"1","customer_id":"1","load_amount":"$1.00","time":"2000-01-01T00:00:00Z","ParsedAmount":1}`
+
+		for _, workers := range []int{1, 4} {
+			var out bytes.Buffer
+			err := processLines(deposit.NewValidator(), "", strings.NewReader(input), &out, &out, options{strictJSON: true, workers: workers})
+
+			assert.NoError(t, err)
+			assert.Equal(t, `{"line":1,"error":"unknown field \"ParsedAmount\""}`+"\n", out.String())
+		}
+	})
+
+	t.Run("processLines should report the invalid fields of a deposit", func(t *testing.T) {
+		var out bytes.Buffer
+		input := `{"id":"1","customer_id":" ","load_amount":"$0.00","time":"2000-01-01T00:00:00Z"}`
+		err := processLines(deposit.NewValidator(), "", strings.NewReader(input), &out, &out, options{})
+
+		assert.NoError(t, err)
+		assert.Equal(t, `{"line":1,"error":"invalid deposit: customer_id is missing, load_amount must be positive","invalid_fields":{"customer_id":"is missing","load_amount":"must be positive"}}`+"\n", out.String())
+	})
+}
+
+func TestProcessLines_Duplicates(t *testing.T) {
+	input := strings.Join([]string{
+		`{"id":"1","customer_id":"1","load_amount":"$4000.00","time":"2000-01-01T00:00:00Z"}`,
+		`{"id":"2","customer_id":"1","load_amount":"$4000.00","time":"2000-01-01T01:00:00Z"}`,
+		`{"id":"2","customer_id":"1","load_amount":"$4000.00","time":"2000-01-01T01:00:00Z"}`,
+		`{"id":"1","customer_id":"1","load_amount":"$4000.00","time":"2000-01-01T00:00:00Z"}`,
+	}, "\n")
+
+	first := []string{
+		`{"id":"1","customer_id":"1","accepted":true}`,
+		`{"id":"2","customer_id":"1","accepted":false,"reasons":["DAILY_AMOUNT_EXCEEDED"]}`,
+	}
+
+	cases := map[deposit.ReplayMode][]string{
+		deposit.ReplaySkip: first,
+		deposit.ReplayEcho: append(first,
+			`{"id":"2","customer_id":"1","accepted":false,"reasons":["DAILY_AMOUNT_EXCEEDED"],"replay":true}`,
+			`{"id":"1","customer_id":"1","accepted":true,"replay":true}`,
+		),
+		deposit.ReplayError: append(first,
+			`{"line":3,"error":"deposit has already been processed"}`,
+			`{"line":4,"error":"deposit has already been processed"}`,
+		),
+	}
+
+	for mode, expected := range cases {
+		t.Run(fmt.Sprintf("processLines should answer duplicates in %s mode", mode), func(t *testing.T) {
+			for _, workers := range []int{1, 4} {
+				var out bytes.Buffer
+				err := processLines(deposit.NewValidator(), "", strings.NewReader(input), &out, &out, options{showReasons: true, duplicates: mode, workers: workers})
+
+				assert.NoError(t, err)
+				assert.Equal(t, strings.Join(expected, "\n")+"\n", out.String(), "%d workers", workers)
+			}
+		})
+	}
+
+	t.Run("processLines should report duplicates whose decision is not remembered in echo mode", func(t *testing.T) {
+		store, _ := deposit.NewMemoryStoreWithFilter(1000, 0.0001)
+		validator := deposit.NewValidatorWithStore(deposit.DefaultPolicy(), store)
+
+		var out bytes.Buffer
+		err := processLines(validator, "", strings.NewReader(input), &out, &out, options{duplicates: deposit.ReplayEcho})
+
+		assert.NoError(t, err)
+		assert.Contains(t, out.String(), `{"line":3,"error":"deposit has already been processed"}`)
+	})
+}
+
+func TestProcessFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "loads.jsonl")
+	input := `{"id":"1","customer_id":"1","load_amount":"$4000.00","time":"2000-01-01T00:00:00Z"}` + "\n" + `not json` + "\n"
+	assert.NoError(t, ioutil.WriteFile(path, []byte(input), 0644))
+
+	t.Run("processFile should name the file in error records", func(t *testing.T) {
+		var out bytes.Buffer
+		err := processFile(deposit.NewValidator(), path, &out, &out, options{})
+
+		assert.NoError(t, err)
+		assert.Contains(t, out.String(), `{"file":"`+path+`","line":2,"error":`)
+	})
+
+	t.Run("processFile should apply the limits across every file", func(t *testing.T) {
+		var out bytes.Buffer
+		validator := deposit.NewValidator()
+		assert.NoError(t, processFile(validator, path, &out, ioutil.Discard, options{}))
+
+		other := filepath.Join(t.TempDir(), "more.jsonl")
+		assert.NoError(t, ioutil.WriteFile(other, []byte(`{"id":"2","customer_id":"1","load_amount":"$4000.00","time":"2000-01-01T01:00:00Z"}`), 0644))
+		assert.NoError(t, processFile(validator, other, &out, ioutil.Discard, options{}))
+
+		assert.Equal(t, `{"id":"1","customer_id":"1","accepted":true}`+"\n"+`{"id":"2","customer_id":"1","accepted":false}`+"\n", out.String())
+	})
+
+	t.Run("processFile should return an error for missing files", func(t *testing.T) {
+		err := processFile(deposit.NewValidator(), filepath.Join(t.TempDir(), "missing.jsonl"), ioutil.Discard, ioutil.Discard, options{})
+		assert.Error(t, err)
+	})
+}
+
+func TestSnapshotFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	t.Run("restoreSnapshot should resume from the state saved by saveSnapshot", func(t *testing.T) {
+		validator := deposit.NewValidator()
+		_, err := processInput(validator, `{"id":"1","customer_id":"1","load_amount":"$4000.00","time":"2000-01-01T00:00:00Z"}`, options{})
+		assert.NoError(t, err)
+		assert.NoError(t, saveSnapshot(validator, path))
+
+		resumed := deposit.NewValidator()
+		assert.NoError(t, restoreSnapshot(resumed, path))
+
+		_, err = processInput(resumed, `{"id":"1","customer_id":"1","load_amount":"$4000.00","time":"2000-01-01T00:00:00Z"}`, options{})
+		assert.Equal(t, deposit.ErrAlreadyProcessed, err)
+
+		response, err := processInput(resumed, `{"id":"2","customer_id":"1","load_amount":"$4000.00","time":"2000-01-01T01:00:00Z"}`, options{})
+		assert.NoError(t, err)
+		assert.Equal(t, `{"id":"2","customer_id":"1","accepted":false}`, response)
+	})
+
+	t.Run("restoreSnapshot should name the file in errors", func(t *testing.T) {
+		invalid := filepath.Join(t.TempDir(), "invalid.json")
+		assert.NoError(t, ioutil.WriteFile(invalid, []byte(`{"version":9}`), 0644))
+
+		err := restoreSnapshot(deposit.NewValidator(), invalid)
+		assert.EqualError(t, err, invalid+": unsupported snapshot version 9")
+	})
+}
+
+func TestOpenStore(t *testing.T) {
+	t.Run("openStore should keep validated deposits in filters if given a size", func(t *testing.T) {
+		store, err := openStore("", 1000)
+		assert.NoError(t, err)
+		assert.IsType(t, &deposit.MemoryStore{}, store)
+
+		// Filters cannot be written to a snapshot
+		err = deposit.NewValidatorWithStore(deposit.DefaultPolicy(), store).Snapshot(ioutil.Discard)
+		assert.Error(t, err)
+	})
+
+	t.Run("openStore should keep validated deposits on disk in the data directory", func(t *testing.T) {
+		store, err := openStore(t.TempDir(), 0)
+		assert.NoError(t, err)
+		assert.IsType(t, &deposit.DiskStore{}, store)
+		store.Close()
+	})
+
+	t.Run("openStore should not keep filters on disk", func(t *testing.T) {
+		_, err := openStore(t.TempDir(), 1000)
+		assert.Error(t, err)
 	})
 }
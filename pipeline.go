@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"hash/fnv"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/travisbale/deposit-validator/deposit"
+)
+
+// The number of lines that can be in the pipeline for each worker
+const linesPerWorker = 64
+
+// A job is a line of input moving through the pipeline. Jobs are passed from stage to stage
+// in input order, and each stage waits for the results of the one before it on the job's
+// channels, which are buffered so that no stage blocks on sending a result.
+type job struct {
+	line   int
+	text   string
+	parsed chan parseResult
+	result chan lineResult
+}
+
+type parseResult struct {
+	deposit *deposit.Deposit
+	err     error
+}
+
+type lineResult struct {
+	response string
+	err      error
+}
+
+// errSkipped is the result of lines after the first that fails in strict mode, which is
+// never written as processing stops at the first failure
+var errSkipped = errors.New("skipped after an earlier line failed")
+
+// processLinesParallel processes the lines of the input in a pipeline. Lines are parsed in
+// parallel and each customer's deposits are validated by a single worker in input order, so
+// deposits are accepted or declined exactly as they would be by processing the lines one at
+// a time, provided load IDs are unique to each customer and are never forgotten. The
+// responses are written in input order. In strict mode nothing after the first line that
+// fails may be validated, so every deposit is validated by one worker, which stops
+// validating once a line fails.
+func processLinesParallel(depositValidator deposit.Validator, in io.Reader, w resultWriter, opts options) error {
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	capacity := opts.workers * linesPerWorker
+
+	parse := make(chan *job, capacity)
+	dispatch := make(chan *job, capacity)
+	output := make(chan *job, capacity)
+
+	// Read the lines, stopping early if the output fails
+	var readErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(parse)
+		defer close(dispatch)
+		defer close(output)
+
+		scanner := bufio.NewScanner(in)
+		for line := 1; scanner.Scan(); line++ {
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+
+			j := &job{line: line, text: scanner.Text(), parsed: make(chan parseResult, 1), result: make(chan lineResult, 1)}
+			for _, stage := range []chan *job{parse, dispatch, output} {
+				select {
+				case stage <- j:
+				case <-done:
+					return
+				}
+			}
+		}
+
+		readErr = scanner.Err()
+	}()
+
+	// Parse the lines in parallel
+	for i := 0; i < opts.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := range parse {
+				d, err := opts.parse(j.text)
+				j.parsed <- parseResult{d, err}
+			}
+		}()
+	}
+
+	// Validate each customer's deposits on the same worker, in the order they were read
+	workers := make([]chan *job, opts.workers)
+	if w.strict {
+		workers = workers[:1]
+	}
+
+	for i := range workers {
+		workers[i] = make(chan *job, linesPerWorker)
+
+		wg.Add(1)
+		go func(jobs chan *job) {
+			defer wg.Done()
+
+			// Set once a line fails in strict mode
+			failed := false
+
+			for j := range jobs {
+				p := <-j.parsed
+				r := lineResult{err: p.err}
+
+				switch {
+				case failed:
+					r.err = errSkipped
+				case p.err == nil:
+					r.response, r.err = validateDeposit(depositValidator, p.deposit, opts)
+				}
+
+				failed = failed || w.stops(r.err)
+				j.result <- r
+			}
+		}(workers[i])
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer func() {
+			for _, jobs := range workers {
+				close(jobs)
+			}
+		}()
+
+		for j := range dispatch {
+			p := <-j.parsed
+
+			// In strict mode the worker has to see the lines that failed to parse, so that it
+			// stops validating the lines after them
+			if p.err != nil && !w.strict {
+				j.result <- lineResult{err: p.err}
+				continue
+			}
+
+			// Hand the parsed deposit on to the worker
+			j.parsed <- p
+			if len(workers) == 1 {
+				workers[0] <- j
+			} else {
+				workers[partition(p.deposit.CustomerID, len(workers))] <- j
+			}
+		}
+	}()
+
+	// Write the responses in the order the lines were read
+	var err error
+	for j := range output {
+		r := <-j.result
+		if err = w.write(j.line, r.response, r.err); err != nil {
+			break
+		}
+	}
+
+	// Let the other stages finish before returning
+	close(done)
+	for range output {
+	}
+	wg.Wait()
+
+	if err != nil {
+		return err
+	}
+
+	return readErr
+}
+
+// partition returns the worker that validates the customer's deposits
+func partition(customerID string, workers int) int {
+	h := fnv.New32a()
+	h.Write([]byte(customerID))
+
+	return int(h.Sum32() % uint32(workers))
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/travisbale/deposit-validator/deposit"
+)
+
+// generateInput returns lines of deposits by the customers, including duplicates and
+// malformed lines
+func generateInput(lines int, customers int) string {
+	r := rand.New(rand.NewSource(1))
+	start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	var b strings.Builder
+
+	for i := 0; i < lines; i++ {
+		id := i
+		switch r.Intn(50) {
+		case 0:
+			b.WriteString("not json\n")
+			continue
+		case 1:
+			id = r.Intn(i + 1)
+		}
+
+		t := start.Add(time.Duration(i) * time.Minute)
+		amount := fmt.Sprintf("$%d.%02d", r.Intn(3000), r.Intn(100))
+		fmt.Fprintf(&b, `{"id":"%d","customer_id":"%d","load_amount":"%s","time":"%s"}`+"\n", id, r.Intn(customers), amount, t.Format(time.RFC3339))
+	}
+
+	return b.String()
+}
+
+func TestProcessLinesParallel(t *testing.T) {
+	input := generateInput(5000, 100)
+
+	var sequential bytes.Buffer
+	assert.NoError(t, processLines(deposit.NewValidator(), "", strings.NewReader(input), &sequential, &sequential, options{showReasons: true}))
+
+	for _, workers := range []int{2, 3, 16} {
+		t.Run(fmt.Sprintf("processLines should write the same output with %d workers", workers), func(t *testing.T) {
+			var parallel bytes.Buffer
+			err := processLines(deposit.NewValidator(), "", strings.NewReader(input), &parallel, &parallel, options{showReasons: true, workers: workers})
+
+			assert.NoError(t, err)
+			assert.Equal(t, sequential.String(), parallel.String())
+		})
+	}
+
+	t.Run("processLines should match the expected output of the example input", func(t *testing.T) {
+		input, err := ioutil.ReadFile("input.txt")
+		assert.NoError(t, err)
+		expected, err := ioutil.ReadFile("output.txt")
+		assert.NoError(t, err)
+
+		var out bytes.Buffer
+		assert.NoError(t, processLines(deposit.NewValidator(), "", bytes.NewReader(input), &out, &out, options{workers: 4}))
+		assert.Equal(t, string(expected), out.String())
+	})
+
+	t.Run("processLines should stop at the first malformed line in strict mode", func(t *testing.T) {
+		var sequential, parallel bytes.Buffer
+		expected := processLines(deposit.NewValidator(), "loads.jsonl", strings.NewReader(input), &sequential, &sequential, options{strict: true})
+		err := processLines(deposit.NewValidator(), "loads.jsonl", strings.NewReader(input), &parallel, &parallel, options{strict: true, workers: 4})
+
+		assert.Error(t, expected)
+		assert.Equal(t, expected, err)
+		assert.Equal(t, sequential.String(), parallel.String())
+	})
+
+	t.Run("processLines should not validate lines after the first malformed line in strict mode", func(t *testing.T) {
+		sequentialValidator, parallelValidator := deposit.NewValidator(), deposit.NewValidator()
+		assert.Error(t, processLines(sequentialValidator, "", strings.NewReader(input), ioutil.Discard, ioutil.Discard, options{strict: true}))
+		assert.Error(t, processLines(parallelValidator, "", strings.NewReader(input), ioutil.Discard, ioutil.Discard, options{strict: true, workers: 8}))
+
+		// Deposits validated but never written would now be treated as already processed
+		var sequential, parallel bytes.Buffer
+		assert.NoError(t, processLines(sequentialValidator, "", strings.NewReader(input), &sequential, &sequential, options{showReasons: true}))
+		assert.NoError(t, processLines(parallelValidator, "", strings.NewReader(input), &parallel, &parallel, options{showReasons: true}))
+		assert.Equal(t, sequential.String(), parallel.String())
+	})
+}
+
+func TestProcessLinesParallel_DedupeWindow(t *testing.T) {
+	policy := deposit.DefaultPolicy()
+	policy.DedupeWindow = 24 * time.Hour
+
+	// Hourly deposits by a few customers, in the order they were made
+	var b strings.Builder
+	start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&b, `{"id":"%d","customer_id":"%d","load_amount":"$1.00","time":"%s"}`+"\n", i, i%7, start.Add(time.Duration(i)*time.Hour).Format(time.RFC3339))
+	}
+	input := b.String()
+
+	var sequential bytes.Buffer
+	assert.NoError(t, processLines(deposit.NewValidatorWithPolicy(policy), "", strings.NewReader(input), &sequential, &sequential, options{showReasons: true}))
+
+	t.Run("processLines should not decline deposits as late because another customer's moved the dedupe window", func(t *testing.T) {
+		var parallel bytes.Buffer
+		err := processLines(deposit.NewValidatorWithPolicy(policy), "", strings.NewReader(input), &parallel, &parallel, options{showReasons: true, workers: 8, dedupeWindow: policy.DedupeWindow})
+
+		assert.NoError(t, err)
+		assert.NotContains(t, parallel.String(), string(deposit.DepositTooLate))
+		assert.Equal(t, sequential.String(), parallel.String())
+	})
+
+	t.Run("processLines should not forget deposits because another customer's later deposit was read first", func(t *testing.T) {
+		// Customer B's deposits and then their duplicates, followed by a deposit by customer A
+		// a month later that would make B's deposits forgotten if it were validated first
+		var b strings.Builder
+		for _, id := range []string{"b", "b"} {
+			for i := 0; i < 20; i++ {
+				fmt.Fprintf(&b, `{"id":"%s%d","customer_id":"B","load_amount":"$1.00","time":"%s"}`+"\n", id, i, start.Add(time.Duration(i)*time.Minute).Format(time.RFC3339))
+			}
+		}
+		fmt.Fprintf(&b, `{"id":"a0","customer_id":"A","load_amount":"$1.00","time":"%s"}`+"\n", start.AddDate(0, 1, 0).Format(time.RFC3339))
+		input := b.String()
+
+		var sequential bytes.Buffer
+		assert.NoError(t, processLines(deposit.NewValidatorWithPolicy(policy), "", strings.NewReader(input), &sequential, &sequential, options{showReasons: true}))
+		assert.Equal(t, 21, strings.Count(sequential.String(), "\n"))
+
+		for i := 0; i < 50; i++ {
+			var parallel bytes.Buffer
+			err := processLines(deposit.NewValidatorWithPolicy(policy), "", strings.NewReader(input), &parallel, &parallel, options{showReasons: true, workers: 8, dedupeWindow: policy.DedupeWindow})
+
+			assert.NoError(t, err)
+			assert.Equal(t, sequential.String(), parallel.String())
+		}
+	})
+}
+
+func TestProcessLinesParallel_GlobalScope(t *testing.T) {
+	policy := deposit.DefaultPolicy()
+	policy.DedupeScope = deposit.GlobalScope
+
+	// Every ID is used by two customers, and only the first line with it is validated
+	var b strings.Builder
+	start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 4000; i++ {
+		fmt.Fprintf(&b, `{"id":"%d","customer_id":"%d","load_amount":"$1.00","time":"%s"}`+"\n", i/2, i%13, start.Add(time.Duration(i)*time.Minute).Format(time.RFC3339))
+	}
+	input := b.String()
+
+	var sequential bytes.Buffer
+	assert.NoError(t, processLines(deposit.NewValidatorWithPolicy(policy), "", strings.NewReader(input), &sequential, &sequential, options{dedupeScope: deposit.GlobalScope}))
+
+	t.Run("processLines should write the same output with workers when IDs are global", func(t *testing.T) {
+		var parallel bytes.Buffer
+		err := processLines(deposit.NewValidatorWithPolicy(policy), "", strings.NewReader(input), &parallel, &parallel, options{workers: 8, dedupeScope: deposit.GlobalScope})
+
+		assert.NoError(t, err)
+		assert.Equal(t, 2000, strings.Count(parallel.String(), "\n"))
+		assert.Equal(t, sequential.String(), parallel.String())
+	})
+}
+
+func benchmarkProcessLines(b *testing.B, workers int) {
+	input := generateInput(20000, 1000)
+	b.SetBytes(int64(len(input)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		err := processLines(deposit.NewValidator(), "", strings.NewReader(input), ioutil.Discard, ioutil.Discard, options{workers: workers})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkProcessLines_Sequential(b *testing.B) { benchmarkProcessLines(b, 1) }
+func BenchmarkProcessLines_Parallel2(b *testing.B)  { benchmarkProcessLines(b, 2) }
+func BenchmarkProcessLines_Parallel4(b *testing.B)  { benchmarkProcessLines(b, 4) }
+func BenchmarkProcessLines_Parallel8(b *testing.B)  { benchmarkProcessLines(b, 8) }
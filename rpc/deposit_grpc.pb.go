@@ -0,0 +1,222 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: deposit.proto
+
+package rpc
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// DepositValidatorClient is the client API for DepositValidator service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type DepositValidatorClient interface {
+	// ValidateDeposit validates a single load. Loads that have already been processed fail
+	// with ALREADY_EXISTS, or are answered with the decision they were given if the service
+	// replays decisions, and malformed loads fail with INVALID_ARGUMENT.
+	ValidateDeposit(ctx context.Context, in *ValidateDepositRequest, opts ...grpc.CallOption) (*ValidateDepositResponse, error)
+	// ValidateDepositStream validates loads in the order they are sent, with a response for
+	// each of them
+	ValidateDepositStream(ctx context.Context, opts ...grpc.CallOption) (DepositValidator_ValidateDepositStreamClient, error)
+	// GetCustomerUsage reports how much of their limits a customer has used
+	GetCustomerUsage(ctx context.Context, in *GetCustomerUsageRequest, opts ...grpc.CallOption) (*GetCustomerUsageResponse, error)
+}
+
+type depositValidatorClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewDepositValidatorClient(cc grpc.ClientConnInterface) DepositValidatorClient {
+	return &depositValidatorClient{cc}
+}
+
+func (c *depositValidatorClient) ValidateDeposit(ctx context.Context, in *ValidateDepositRequest, opts ...grpc.CallOption) (*ValidateDepositResponse, error) {
+	out := new(ValidateDepositResponse)
+	err := c.cc.Invoke(ctx, "/deposit.v1.DepositValidator/ValidateDeposit", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *depositValidatorClient) ValidateDepositStream(ctx context.Context, opts ...grpc.CallOption) (DepositValidator_ValidateDepositStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &DepositValidator_ServiceDesc.Streams[0], "/deposit.v1.DepositValidator/ValidateDepositStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &depositValidatorValidateDepositStreamClient{stream}
+	return x, nil
+}
+
+type DepositValidator_ValidateDepositStreamClient interface {
+	Send(*ValidateDepositRequest) error
+	Recv() (*ValidateDepositStreamResponse, error)
+	grpc.ClientStream
+}
+
+type depositValidatorValidateDepositStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *depositValidatorValidateDepositStreamClient) Send(m *ValidateDepositRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *depositValidatorValidateDepositStreamClient) Recv() (*ValidateDepositStreamResponse, error) {
+	m := new(ValidateDepositStreamResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *depositValidatorClient) GetCustomerUsage(ctx context.Context, in *GetCustomerUsageRequest, opts ...grpc.CallOption) (*GetCustomerUsageResponse, error) {
+	out := new(GetCustomerUsageResponse)
+	err := c.cc.Invoke(ctx, "/deposit.v1.DepositValidator/GetCustomerUsage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// DepositValidatorServer is the server API for DepositValidator service.
+// All implementations must embed UnimplementedDepositValidatorServer
+// for forward compatibility
+type DepositValidatorServer interface {
+	// ValidateDeposit validates a single load. Loads that have already been processed fail
+	// with ALREADY_EXISTS, or are answered with the decision they were given if the service
+	// replays decisions, and malformed loads fail with INVALID_ARGUMENT.
+	ValidateDeposit(context.Context, *ValidateDepositRequest) (*ValidateDepositResponse, error)
+	// ValidateDepositStream validates loads in the order they are sent, with a response for
+	// each of them
+	ValidateDepositStream(DepositValidator_ValidateDepositStreamServer) error
+	// GetCustomerUsage reports how much of their limits a customer has used
+	GetCustomerUsage(context.Context, *GetCustomerUsageRequest) (*GetCustomerUsageResponse, error)
+	mustEmbedUnimplementedDepositValidatorServer()
+}
+
+// UnimplementedDepositValidatorServer must be embedded to have forward compatible implementations.
+type UnimplementedDepositValidatorServer struct {
+}
+
+func (UnimplementedDepositValidatorServer) ValidateDeposit(context.Context, *ValidateDepositRequest) (*ValidateDepositResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ValidateDeposit not implemented")
+}
+func (UnimplementedDepositValidatorServer) ValidateDepositStream(DepositValidator_ValidateDepositStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method ValidateDepositStream not implemented")
+}
+func (UnimplementedDepositValidatorServer) GetCustomerUsage(context.Context, *GetCustomerUsageRequest) (*GetCustomerUsageResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCustomerUsage not implemented")
+}
+func (UnimplementedDepositValidatorServer) mustEmbedUnimplementedDepositValidatorServer() {}
+
+// UnsafeDepositValidatorServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to DepositValidatorServer will
+// result in compilation errors.
+type UnsafeDepositValidatorServer interface {
+	mustEmbedUnimplementedDepositValidatorServer()
+}
+
+func RegisterDepositValidatorServer(s grpc.ServiceRegistrar, srv DepositValidatorServer) {
+	s.RegisterService(&DepositValidator_ServiceDesc, srv)
+}
+
+func _DepositValidator_ValidateDeposit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ValidateDepositRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DepositValidatorServer).ValidateDeposit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/deposit.v1.DepositValidator/ValidateDeposit",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DepositValidatorServer).ValidateDeposit(ctx, req.(*ValidateDepositRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _DepositValidator_ValidateDepositStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(DepositValidatorServer).ValidateDepositStream(&depositValidatorValidateDepositStreamServer{stream})
+}
+
+type DepositValidator_ValidateDepositStreamServer interface {
+	Send(*ValidateDepositStreamResponse) error
+	Recv() (*ValidateDepositRequest, error)
+	grpc.ServerStream
+}
+
+type depositValidatorValidateDepositStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *depositValidatorValidateDepositStreamServer) Send(m *ValidateDepositStreamResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *depositValidatorValidateDepositStreamServer) Recv() (*ValidateDepositRequest, error) {
+	m := new(ValidateDepositRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _DepositValidator_GetCustomerUsage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetCustomerUsageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(DepositValidatorServer).GetCustomerUsage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/deposit.v1.DepositValidator/GetCustomerUsage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(DepositValidatorServer).GetCustomerUsage(ctx, req.(*GetCustomerUsageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// DepositValidator_ServiceDesc is the grpc.ServiceDesc for DepositValidator service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var DepositValidator_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "deposit.v1.DepositValidator",
+	HandlerType: (*DepositValidatorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ValidateDeposit",
+			Handler:    _DepositValidator_ValidateDeposit_Handler,
+		},
+		{
+			MethodName: "GetCustomerUsage",
+			Handler:    _DepositValidator_GetCustomerUsage_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ValidateDepositStream",
+			Handler:       _DepositValidator_ValidateDepositStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "deposit.proto",
+}
@@ -0,0 +1,230 @@
+// Package rpc exposes a deposit validator as a gRPC service. The service is defined in
+// deposit.proto, and the generated code is updated with go generate.
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/travisbale/deposit-validator/deposit"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Service implements the DepositValidator gRPC service
+type Service struct {
+	UnimplementedDepositValidatorServer
+
+	validator  deposit.Validator
+	duplicates deposit.ReplayMode
+}
+
+// NewService creates a service that validates deposits with the validator
+func NewService(validator deposit.Validator) *Service {
+	return &Service{validator: validator, duplicates: deposit.ReplayError}
+}
+
+// SetReplayMode sets how deposits that have already been processed are answered, which is
+// deposit.ReplayError by default. In echo mode a deposit is answered with the decision first
+// made for it, if the validator remembers it, and in skip mode streams leave it out. Other
+// deposits that have already been processed fail with ALREADY_EXISTS. It must be called
+// before the service handles any calls.
+func (s *Service) SetReplayMode(mode deposit.ReplayMode) {
+	s.duplicates = mode
+}
+
+func (s *Service) ValidateDeposit(ctx context.Context, req *ValidateDepositRequest) (*ValidateDepositResponse, error) {
+	return s.validate(req)
+}
+
+func (s *Service) ValidateDepositStream(stream DepositValidator_ValidateDepositStreamServer) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// Deposits that cannot be validated are reported without ending the stream
+		var resp ValidateDepositStreamResponse
+		if decision, err := s.validate(req); status.Code(err) == codes.AlreadyExists && s.duplicates == deposit.ReplaySkip {
+			continue
+		} else if err != nil {
+			st := status.Convert(err)
+			resp.Result = &ValidateDepositStreamResponse_Error{Error: &Error{
+				Id:         req.Id,
+				CustomerId: req.CustomerId,
+				Code:       int32(st.Code()),
+				Message:    st.Message(),
+			}}
+		} else {
+			resp.Result = &ValidateDepositStreamResponse_Decision{Decision: decision}
+		}
+
+		if err := stream.Send(&resp); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Service) GetCustomerUsage(ctx context.Context, req *GetCustomerUsageRequest) (*GetCustomerUsageResponse, error) {
+	if req.CustomerId == "" {
+		return nil, status.Error(codes.InvalidArgument, "missing customer_id")
+	}
+
+	usages, err := s.validator.Usage(req.CustomerId, timeOrZero(req.Time))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	var resp GetCustomerUsageResponse
+
+	for _, usage := range usages {
+		resp.Accounts = append(resp.Accounts, &AccountUsage{
+			Currency: usage.Currency,
+			Combined: usage.Combined,
+			Limits:   newLimits(usage.Currency, usage.Limits),
+			Daily:    newLedger(usage.Currency, usage.Daily),
+			Weekly:   newLedger(usage.Currency, usage.Weekly),
+			Monthly:  newLedger(usage.Currency, usage.Monthly),
+		})
+	}
+
+	return &resp, nil
+}
+
+// validate validates the deposit unless it has already been processed, in which case the
+// decision first made for it is returned in echo mode
+func (s *Service) validate(req *ValidateDepositRequest) (*ValidateDepositResponse, error) {
+	d, err := newDeposit(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	var invalid *deposit.ValidationError
+
+	decision, err := s.validator.ValidateOnce(d)
+	if err == deposit.ErrAlreadyProcessed && s.duplicates == deposit.ReplayEcho && decision.Replay {
+		return newDecision(decision), nil
+	}
+	if err == deposit.ErrAlreadyProcessed {
+		return nil, status.Error(codes.AlreadyExists, err.Error())
+	}
+	if errors.As(err, &invalid) {
+		return nil, invalidArgument(invalid)
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	return newDecision(decision), nil
+}
+
+// newDeposit converts a request into a deposit, returning an error if the time cannot be
+// converted. Missing fields are reported when the deposit is validated.
+func newDeposit(req *ValidateDepositRequest) (*deposit.Deposit, error) {
+	d := &deposit.Deposit{
+		ID:         req.Id,
+		CustomerID: req.CustomerId,
+		Amount:     req.LoadAmount,
+		Currency:   req.Currency,
+	}
+
+	if req.Time != nil {
+		if err := req.Time.CheckValid(); err != nil {
+			return nil, err
+		}
+
+		d.Time = req.Time.AsTime()
+	}
+
+	return d, nil
+}
+
+// invalidArgument returns an InvalidArgument status with a violation for each invalid field
+func invalidArgument(invalid *deposit.ValidationError) error {
+	violations := make([]*errdetails.BadRequest_FieldViolation, len(invalid.Fields))
+	for i, field := range invalid.Fields {
+		violations[i] = &errdetails.BadRequest_FieldViolation{Field: field.Field, Description: field.Err.Error()}
+	}
+
+	st, err := status.New(codes.InvalidArgument, invalid.Error()).WithDetails(&errdetails.BadRequest{FieldViolations: violations})
+	if err != nil {
+		return status.Error(codes.InvalidArgument, invalid.Error())
+	}
+
+	return st.Err()
+}
+
+func newDecision(decision deposit.Decision) *ValidateDepositResponse {
+	resp := &ValidateDepositResponse{
+		Id:         decision.ID,
+		CustomerId: decision.CustomerID,
+		Accepted:   decision.Accepted,
+		Replay:     decision.Replay,
+	}
+
+	for _, reason := range decision.Reasons {
+		resp.Reasons = append(resp.Reasons, string(reason))
+	}
+
+	if c := decision.Conversion; c != nil {
+		resp.Conversion = &Conversion{Currency: c.Currency, Amount: format(c.Currency, c.Amount), Rate: c.Rate.String()}
+	}
+
+	return resp
+}
+
+func newLimits(currency string, limits deposit.Limits) *Limits {
+	l := &Limits{
+		DailyAmount:        format(currency, limits.DailyAmount),
+		WeeklyAmount:       format(currency, limits.WeeklyAmount),
+		MaxDailyDeposits:   int32(limits.MaxDailyDeposits),
+		MonthlyAmount:      format(currency, limits.MonthlyAmount),
+		MaxMonthlyDeposits: int32(limits.MaxMonthlyDeposits),
+	}
+
+	for _, rolling := range limits.Rolling {
+		l.Rolling = append(l.Rolling, &RollingLimit{
+			Window:      durationpb.New(rolling.Window),
+			Amount:      format(currency, rolling.Amount),
+			MaxDeposits: int32(rolling.MaxDeposits),
+		})
+	}
+
+	return l
+}
+
+func newLedger(currency string, ledger deposit.Ledger) *Ledger {
+	return &Ledger{
+		Start:    timestamppb.New(ledger.Start),
+		Deposits: int32(ledger.Deposits),
+		Total:    format(currency, ledger.Total),
+	}
+}
+
+// format formats an amount with the symbol of its currency
+func format(currency string, m deposit.Money) string {
+	c, err := deposit.LookupCurrency(currency)
+	if err != nil {
+		return m.String()
+	}
+
+	return c.Format(m)
+}
+
+// timeOrZero returns the time of the timestamp, or the zero time if it is not set
+func timeOrZero(t *timestamppb.Timestamp) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+
+	return t.AsTime()
+}
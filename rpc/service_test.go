@@ -0,0 +1,236 @@
+package rpc
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/travisbale/deposit-validator/deposit"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// newClient starts the service on an in-memory listener and connects a client to it
+func newClient(t *testing.T, service *Service) DepositValidatorClient {
+	listener := bufconn.Listen(1 << 20)
+	server := grpc.NewServer()
+	RegisterDepositValidatorServer(server, service)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+
+	dialer := func(context.Context, string) (net.Conn, error) { return listener.Dial() }
+	conn, err := grpc.Dial("bufnet", grpc.WithContextDialer(dialer), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return NewDepositValidatorClient(conn)
+}
+
+func newRequest(id string, customerID string, amount string, t time.Time) *ValidateDepositRequest {
+	return &ValidateDepositRequest{Id: id, CustomerId: customerID, LoadAmount: amount, Time: timestamppb.New(t)}
+}
+
+func TestService_ValidateDeposit(t *testing.T) {
+	client := newClient(t, NewService(deposit.NewValidator()))
+	ctx := context.Background()
+
+	t.Run("ValidateDeposit should return the decision", func(t *testing.T) {
+		resp, err := client.ValidateDeposit(ctx, newRequest("1", "1", "$4000.00", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+		assert.NoError(t, err)
+		assert.True(t, resp.Accepted)
+
+		resp, err = client.ValidateDeposit(ctx, newRequest("2", "1", "$4000.00", time.Date(2000, 1, 1, 1, 0, 0, 0, time.UTC)))
+		assert.NoError(t, err)
+		assert.False(t, resp.Accepted)
+		assert.Equal(t, []string{"DAILY_AMOUNT_EXCEEDED"}, resp.Reasons)
+	})
+
+	t.Run("ValidateDeposit should fail for deposits that have already been processed", func(t *testing.T) {
+		_, err := client.ValidateDeposit(ctx, newRequest("1", "1", "$4000.00", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+		assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	})
+
+	t.Run("ValidateDeposit should fail for deposits with missing fields", func(t *testing.T) {
+		_, err := client.ValidateDeposit(ctx, &ValidateDepositRequest{Id: "3", CustomerId: "1", LoadAmount: "$1.00"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Equal(t, "invalid deposit: time is missing", status.Convert(err).Message())
+	})
+
+	t.Run("ValidateDeposit should describe each invalid field", func(t *testing.T) {
+		_, err := client.ValidateDeposit(ctx, &ValidateDepositRequest{Id: " ", LoadAmount: "$0.00"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+		details := status.Convert(err).Details()
+		if assert.Len(t, details, 1) {
+			var violations []string
+			for _, violation := range details[0].(*errdetails.BadRequest).FieldViolations {
+				violations = append(violations, violation.Field+" "+violation.Description)
+			}
+			assert.Equal(t, []string{"id is missing", "customer_id is missing", "load_amount must be positive", "time is missing"}, violations)
+		}
+	})
+}
+
+func TestService_ValidateDepositStream(t *testing.T) {
+	client := newClient(t, NewService(deposit.NewValidator()))
+
+	t.Run("ValidateDepositStream should respond to each deposit in order", func(t *testing.T) {
+		stream, err := client.ValidateDepositStream(context.Background())
+		assert.NoError(t, err)
+
+		requests := []*ValidateDepositRequest{
+			newRequest("1", "1", "$4000.00", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)),
+			newRequest("1", "1", "$4000.00", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)),
+			{Id: "2", CustomerId: "1"},
+			newRequest("3", "1", "$4000.00", time.Date(2000, 1, 1, 1, 0, 0, 0, time.UTC)),
+		}
+		for _, req := range requests {
+			assert.NoError(t, stream.Send(req))
+		}
+		assert.NoError(t, stream.CloseSend())
+
+		var responses []*ValidateDepositStreamResponse
+		for range requests {
+			resp, err := stream.Recv()
+			assert.NoError(t, err)
+			responses = append(responses, resp)
+		}
+
+		assert.True(t, responses[0].GetDecision().Accepted)
+		assert.Equal(t, int32(codes.AlreadyExists), responses[1].GetError().Code)
+		assert.Equal(t, "1", responses[1].GetError().Id)
+		assert.Equal(t, int32(codes.InvalidArgument), responses[2].GetError().Code)
+		assert.Equal(t, []string{"DAILY_AMOUNT_EXCEEDED"}, responses[3].GetDecision().Reasons)
+	})
+}
+
+func TestService_Replay(t *testing.T) {
+	requests := []*ValidateDepositRequest{
+		newRequest("1", "1", "$4000.00", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)),
+		newRequest("2", "1", "$4000.00", time.Date(2000, 1, 1, 1, 0, 0, 0, time.UTC)),
+		newRequest("1", "1", "$4000.00", time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)),
+		newRequest("2", "1", "$4000.00", time.Date(2000, 1, 1, 1, 0, 0, 0, time.UTC)),
+	}
+
+	// validateStream sends the requests on a stream and returns the responses
+	validateStream := func(t *testing.T, client DepositValidatorClient) []*ValidateDepositStreamResponse {
+		stream, err := client.ValidateDepositStream(context.Background())
+		assert.NoError(t, err)
+
+		for _, req := range requests {
+			assert.NoError(t, stream.Send(req))
+		}
+		assert.NoError(t, stream.CloseSend())
+
+		var responses []*ValidateDepositStreamResponse
+		for {
+			resp, err := stream.Recv()
+			if err != nil {
+				assert.Equal(t, io.EOF, err)
+				return responses
+			}
+			responses = append(responses, resp)
+		}
+	}
+
+	t.Run("ValidateDeposit should return the original decision in echo mode", func(t *testing.T) {
+		service := NewService(deposit.NewValidator())
+		service.SetReplayMode(deposit.ReplayEcho)
+		client := newClient(t, service)
+		ctx := context.Background()
+
+		for _, req := range requests[:2] {
+			_, err := client.ValidateDeposit(ctx, req)
+			assert.NoError(t, err)
+		}
+
+		resp, err := client.ValidateDeposit(ctx, requests[3])
+		assert.NoError(t, err)
+		assert.False(t, resp.Accepted)
+		assert.Equal(t, []string{"DAILY_AMOUNT_EXCEEDED"}, resp.Reasons)
+		assert.True(t, resp.Replay)
+	})
+
+	t.Run("ValidateDeposit should fail for deposits that have already been processed in skip mode", func(t *testing.T) {
+		service := NewService(deposit.NewValidator())
+		service.SetReplayMode(deposit.ReplaySkip)
+		client := newClient(t, service)
+		ctx := context.Background()
+
+		_, err := client.ValidateDeposit(ctx, requests[0])
+		assert.NoError(t, err)
+
+		_, err = client.ValidateDeposit(ctx, requests[2])
+		assert.Equal(t, codes.AlreadyExists, status.Code(err))
+	})
+
+	t.Run("ValidateDepositStream should answer duplicates according to the replay mode", func(t *testing.T) {
+		service := NewService(deposit.NewValidator())
+		service.SetReplayMode(deposit.ReplayEcho)
+
+		responses := validateStream(t, newClient(t, service))
+		if assert.Len(t, responses, 4) {
+			assert.True(t, responses[2].GetDecision().Accepted)
+			assert.True(t, responses[2].GetDecision().Replay)
+			assert.Equal(t, []string{"DAILY_AMOUNT_EXCEEDED"}, responses[3].GetDecision().Reasons)
+			assert.True(t, responses[3].GetDecision().Replay)
+		}
+
+		service = NewService(deposit.NewValidator())
+		service.SetReplayMode(deposit.ReplaySkip)
+
+		responses = validateStream(t, newClient(t, service))
+		if assert.Len(t, responses, 2) {
+			assert.False(t, responses[0].GetDecision().Replay)
+			assert.False(t, responses[1].GetDecision().Replay)
+		}
+
+		responses = validateStream(t, newClient(t, NewService(deposit.NewValidator())))
+		if assert.Len(t, responses, 4) {
+			assert.Equal(t, int32(codes.AlreadyExists), responses[2].GetError().Code)
+			assert.Equal(t, int32(codes.AlreadyExists), responses[3].GetError().Code)
+		}
+	})
+}
+
+func TestService_GetCustomerUsage(t *testing.T) {
+	client := newClient(t, NewService(deposit.NewValidator()))
+	ctx := context.Background()
+
+	_, err := client.ValidateDeposit(ctx, newRequest("1", "1", "€100.00", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC)))
+	assert.NoError(t, err)
+
+	t.Run("GetCustomerUsage should report the customer's accounts", func(t *testing.T) {
+		resp, err := client.GetCustomerUsage(ctx, &GetCustomerUsageRequest{CustomerId: "1"})
+		assert.NoError(t, err)
+		assert.Len(t, resp.Accounts, 1)
+
+		account := resp.Accounts[0]
+		assert.Equal(t, "EUR", account.Currency)
+		assert.Equal(t, "€5000.00", account.Limits.DailyAmount)
+		assert.Equal(t, int32(1), account.Daily.Deposits)
+		assert.Equal(t, "€100.00", account.Daily.Total)
+		assert.Equal(t, time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC), account.Weekly.Start.AsTime())
+	})
+
+	t.Run("GetCustomerUsage should report the periods containing the given time", func(t *testing.T) {
+		resp, err := client.GetCustomerUsage(ctx, &GetCustomerUsageRequest{CustomerId: "1", Time: timestamppb.New(time.Date(2021, 1, 11, 0, 0, 0, 0, time.UTC))})
+		assert.NoError(t, err)
+		assert.Equal(t, "€0.00", resp.Accounts[0].Weekly.Total)
+		assert.Equal(t, "€100.00", resp.Accounts[0].Monthly.Total)
+	})
+
+	t.Run("GetCustomerUsage should require a customer", func(t *testing.T) {
+		_, err := client.GetCustomerUsage(ctx, &GetCustomerUsageRequest{})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/travisbale/deposit-validator/deposit"
+	"github.com/travisbale/deposit-validator/rpc"
+	"github.com/travisbale/deposit-validator/server"
+	"google.golang.org/grpc"
+)
+
+// serve runs the validator as an HTTP server until it receives an interrupt or terminate
+// signal, then waits for the requests in progress to finish
+func serve(args []string) error {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", ":8080", "address to listen on")
+	policyFile := flags.String("policy", "", "path to a YAML or JSON file of velocity limits")
+	dataDir := flags.String("data", "", "directory to keep the deposits validated in, so they are remembered after a restart")
+	filterDeposits := flags.Int("filter", 0, "remember validated deposits in bloom filters sized for this many deposits a day, instead of by ID")
+	strictJSON := flags.Bool("strict-json", false, "reject deposits with unknown, repeated or null fields instead of ignoring the fields")
+	duplicates := flags.String("duplicates", string(deposit.ReplaySkip), "how to answer deposits that have already been processed: echo the original decision, skip them, or write an error; single deposits that are not echoed are a conflict")
+	drainDelay := flags.Duration("drain-delay", 5*time.Second, "how long to keep serving requests after reporting the server is not ready, so that load balancers stop sending it traffic")
+	shutdownTimeout := flags.Duration("shutdown-timeout", 10*time.Second, "how long to wait for requests to finish when shutting down")
+	flags.Parse(args)
+
+	policy, err := loadPolicy(*policyFile)
+	if err != nil {
+		return err
+	}
+
+	replayMode, err := deposit.ParseReplayMode(*duplicates)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(*dataDir, *filterDeposits)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	validator := deposit.NewValidatorWithStore(policy, store)
+	defer watchOverrides(validator, policy.OverridesPath(*policyFile))()
+
+	handler := server.New(validator)
+	if *strictJSON {
+		handler = server.NewStrict(validator)
+	}
+	handler.SetReplayMode(replayMode)
+	httpServer := &http.Server{Addr: *addr, Handler: handler}
+
+	errs := make(chan error, 1)
+	go func() {
+		log.Printf("listening on %s", *addr)
+		errs <- httpServer.ListenAndServe()
+	}()
+
+	if err := waitForSignal(errs); err != nil {
+		return err
+	}
+
+	return shutdown(httpServer, handler, *drainDelay, *shutdownTimeout)
+}
+
+// shutdown reports that the server is not ready and keeps serving requests for the drain
+// delay, so that load balancers take it out of rotation first. It then stops accepting
+// connections and waits up to the timeout for the requests in progress to finish.
+func shutdown(httpServer *http.Server, handler *server.Server, drainDelay time.Duration, timeout time.Duration) error {
+	handler.Stop()
+
+	log.Printf("draining for %s", drainDelay)
+	time.Sleep(drainDelay)
+
+	log.Print("shutting down")
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return httpServer.Shutdown(ctx)
+}
+
+// serveGRPC runs the validator as a gRPC service until it receives an interrupt or
+// terminate signal, then waits for the calls in progress to finish
+func serveGRPC(args []string) error {
+	flags := flag.NewFlagSet("serve-grpc", flag.ExitOnError)
+	addr := flags.String("addr", ":9090", "address to listen on")
+	policyFile := flags.String("policy", "", "path to a YAML or JSON file of velocity limits")
+	dataDir := flags.String("data", "", "directory to keep the deposits validated in, so they are remembered after a restart")
+	filterDeposits := flags.Int("filter", 0, "remember validated deposits in bloom filters sized for this many deposits a day, instead of by ID")
+	duplicates := flags.String("duplicates", string(deposit.ReplayError), "how to answer deposits that have already been processed: echo the original decision, skip them in streams, or fail with ALREADY_EXISTS")
+	shutdownTimeout := flags.Duration("shutdown-timeout", 10*time.Second, "how long to wait for calls to finish when shutting down")
+	flags.Parse(args)
+
+	policy, err := loadPolicy(*policyFile)
+	if err != nil {
+		return err
+	}
+
+	replayMode, err := deposit.ParseReplayMode(*duplicates)
+	if err != nil {
+		return err
+	}
+
+	store, err := openStore(*dataDir, *filterDeposits)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	validator := deposit.NewValidatorWithStore(policy, store)
+	defer watchOverrides(validator, policy.OverridesPath(*policyFile))()
+
+	service := rpc.NewService(validator)
+	service.SetReplayMode(replayMode)
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+
+	grpcServer := grpc.NewServer()
+	rpc.RegisterDepositValidatorServer(grpcServer, service)
+
+	errs := make(chan error, 1)
+	go func() {
+		log.Printf("listening on %s", *addr)
+		errs <- grpcServer.Serve(listener)
+	}()
+
+	if err := waitForSignal(errs); err != nil {
+		return err
+	}
+
+	log.Print("shutting down")
+
+	// Streams can stay open indefinitely, so they are closed if they outlast the timeout
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(*shutdownTimeout):
+		grpcServer.Stop()
+	}
+
+	return nil
+}
+
+// watchOverrides reads the overrides file again whenever the process receives a hangup
+// signal, so that customers can be moved between tiers without a restart. It returns a
+// function that stops watching for the signal.
+func watchOverrides(validator deposit.Validator, path string) func() {
+	if path == "" {
+		return func() {}
+	}
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	go func() {
+		for range hangup {
+			if err := reloadOverrides(validator, path); err != nil {
+				log.Printf("keeping the current overrides: %v", err)
+			} else {
+				log.Printf("reloaded overrides from %s", path)
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(hangup)
+		close(hangup)
+	}
+}
+
+// reloadOverrides replaces the validator's overrides with those in the file, leaving them
+// as they were if the file cannot be read or refers to tiers the policy does not have
+func reloadOverrides(validator deposit.Validator, path string) error {
+	overrides, err := deposit.LoadOverrides(path)
+	if err != nil {
+		return err
+	}
+
+	return validator.SetOverrides(overrides)
+}
+
+// waitForSignal waits for an interrupt or terminate signal, returning early with the error
+// if the server fails
+func waitForSignal(errs <-chan error) error {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(stop)
+
+	select {
+	case err := <-errs:
+		return err
+	case <-stop:
+		return nil
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/travisbale/deposit-validator/deposit"
+	"github.com/travisbale/deposit-validator/server"
+)
+
+func TestWatchOverrides(t *testing.T) {
+	dir := t.TempDir()
+	policyFile := filepath.Join(dir, "policy.yaml")
+	overridesFile := filepath.Join(dir, "overrides.yaml")
+
+	writeFile := func(path string, contents string) {
+		assert.NoError(t, ioutil.WriteFile(path, []byte(contents), 0644))
+	}
+
+	writeFile(policyFile, "currencies:\n  USD: { daily_amount: 5000.00, weekly_amount: 20000.00, max_daily_deposits: 3 }\ntiers:\n  premium:\n    USD: { daily_amount: 10000.00 }\noverrides_file: overrides.yaml\n")
+	writeFile(overridesFile, "customers:\n  \"1\":\n    tier: premium\n")
+
+	policy, err := loadPolicy(policyFile)
+	assert.NoError(t, err)
+
+	validator := deposit.NewValidatorWithPolicy(policy)
+	validator.Validate(&deposit.Deposit{ID: "1", CustomerID: "1", Amount: "$1.00", Time: time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)})
+
+	// dailyAmount returns the daily limit applied to the customer's deposits
+	dailyAmount := func() deposit.Money {
+		usage, err := validator.Usage("1", time.Time{})
+		assert.NoError(t, err)
+		return usage[0].Limits.DailyAmount
+	}
+
+	t.Run("reloadOverrides should replace the overrides with those in the file", func(t *testing.T) {
+		writeFile(overridesFile, "customers: {}\n")
+		assert.NoError(t, reloadOverrides(validator, policy.OverridesPath(policyFile)))
+		assert.Equal(t, 5000*deposit.Dollar, dailyAmount())
+	})
+
+	t.Run("reloadOverrides should keep the overrides if the file cannot be used", func(t *testing.T) {
+		writeFile(overridesFile, "customers:\n  \"1\":\n    tier: gold\n")
+		assert.Error(t, reloadOverrides(validator, policy.OverridesPath(policyFile)))
+		assert.Equal(t, 5000*deposit.Dollar, dailyAmount())
+
+		assert.Error(t, reloadOverrides(validator, filepath.Join(dir, "missing.yaml")))
+		assert.Equal(t, 5000*deposit.Dollar, dailyAmount())
+	})
+
+	t.Run("watchOverrides should reload the overrides on a hangup signal", func(t *testing.T) {
+		stop := watchOverrides(validator, policy.OverridesPath(policyFile))
+		defer stop()
+
+		writeFile(overridesFile, "customers:\n  \"1\":\n    tier: premium\n")
+
+		process, err := os.FindProcess(os.Getpid())
+		assert.NoError(t, err)
+		assert.NoError(t, process.Signal(syscall.SIGHUP))
+
+		assert.Eventually(t, func() bool { return dailyAmount() == 10000*deposit.Dollar }, time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := server.New(deposit.NewValidator())
+	httpServer := &http.Server{Handler: handler}
+	go httpServer.Serve(listener)
+
+	url := "http://" + listener.Addr().String()
+
+	// status returns the status of a GET request to the path, or 0 if it could not be made
+	status := func(path string) int {
+		resp, err := http.Get(url + path)
+		if err != nil {
+			return 0
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, status("/readyz"))
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- shutdown(httpServer, handler, 200*time.Millisecond, time.Second) }()
+
+	t.Run("shutdown should keep serving requests while the server is drained", func(t *testing.T) {
+		assert.Eventually(t, func() bool { return status("/readyz") == http.StatusServiceUnavailable }, 100*time.Millisecond, 5*time.Millisecond)
+		assert.Equal(t, http.StatusOK, status("/healthz"))
+	})
+
+	t.Run("shutdown should stop the server after the drain delay", func(t *testing.T) {
+		assert.NoError(t, <-stopped)
+		assert.Equal(t, 0, status("/healthz"))
+	})
+}
@@ -0,0 +1,245 @@
+// Package server exposes a deposit validator over HTTP
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/travisbale/deposit-validator/deposit"
+)
+
+// The largest request body accepted for a single deposit
+const maxDepositSize = 1 << 20
+
+// An errorResponse reports a deposit that could not be processed. The line is only given
+// for deposits in a batch, and the invalid fields for deposits whose fields cannot be used.
+type errorResponse struct {
+	Line   int               `json:"line,omitempty"`
+	Error  string            `json:"error"`
+	Fields map[string]string `json:"invalid_fields,omitempty"`
+}
+
+// newErrorResponse reports the error, along with the invalid fields of a deposit if it is a
+// deposit.ValidationError
+func newErrorResponse(line int, err error) errorResponse {
+	response := errorResponse{Line: line, Error: err.Error()}
+
+	var invalid *deposit.ValidationError
+	if errors.As(err, &invalid) {
+		response.Fields = invalid.Problems()
+	}
+
+	return response
+}
+
+// A Server validates deposits received over HTTP. Responses always include the reasons
+// deposits were rejected.
+type Server struct {
+	validator deposit.Validator
+
+	// Parses the deposits in requests
+	parse func(string) (*deposit.Deposit, error)
+
+	// How deposits that have already been processed are answered
+	duplicates deposit.ReplayMode
+
+	// Set once the server should no longer receive traffic
+	stopping int32
+
+	mux *http.ServeMux
+}
+
+// New creates a server that validates deposits with the validator
+func New(validator deposit.Validator) *Server {
+	s := &Server{validator: validator, parse: deposit.ParseJson, duplicates: deposit.ReplaySkip, mux: http.NewServeMux()}
+
+	s.mux.HandleFunc("/deposits", s.handleDeposit)
+	s.mux.HandleFunc("/deposits/batch", s.handleBatch)
+	s.mux.HandleFunc("/healthz", s.handleHealth)
+	s.mux.HandleFunc("/readyz", s.handleReady)
+	s.mux.HandleFunc("/metrics", s.handleMetrics)
+
+	return s
+}
+
+// NewStrict creates a server that only accepts deposits that follow the published schema
+// exactly, as parsed by deposit.ParseJsonStrict
+func NewStrict(validator deposit.Validator) *Server {
+	s := New(validator)
+	s.parse = deposit.ParseJsonStrict
+
+	return s
+}
+
+// SetReplayMode sets how deposits that have already been processed are answered, which is
+// deposit.ReplaySkip by default. In echo mode a single deposit is answered with the decision
+// first made for it, if the validator remembers it, and otherwise it is a conflict. It must
+// be called before the server handles any requests.
+func (s *Server) SetReplayMode(mode deposit.ReplayMode) {
+	s.duplicates = mode
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Stop marks the server as not ready so that it is taken out of rotation before it shuts down
+func (s *Server) Stop() {
+	atomic.StoreInt32(&s.stopping, 1)
+}
+
+// handleDeposit validates a single deposit
+func (s *Server) handleDeposit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxDepositSize))
+	if err != nil {
+		writeJSON(w, http.StatusRequestEntityTooLarge, errorResponse{Error: err.Error()})
+		return
+	}
+
+	d, err := s.parse(string(body))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, errorResponse{Error: err.Error()})
+		return
+	}
+
+	var invalid *deposit.ValidationError
+
+	decision, err := s.validator.ValidateOnce(d)
+	switch {
+	case err == deposit.ErrAlreadyProcessed && s.echo(decision):
+		writeJSON(w, http.StatusOK, decision)
+	case err == deposit.ErrAlreadyProcessed:
+		writeJSON(w, http.StatusConflict, errorResponse{Error: err.Error()})
+	case errors.As(err, &invalid):
+		writeJSON(w, http.StatusUnprocessableEntity, newErrorResponse(0, err))
+	case err != nil:
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+	default:
+		writeJSON(w, http.StatusOK, decision)
+	}
+}
+
+// handleBatch validates newline delimited deposits in order, responding with a line for
+// each decision or malformed deposit. Deposits that have already been processed are
+// answered according to the replay mode, as they are by the command line.
+func (s *Server) handleBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+	scanner := bufio.NewScanner(r.Body)
+
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		decision, err := s.validate(scanner.Text())
+		switch {
+		case err == deposit.ErrAlreadyProcessed && s.echo(decision):
+			err = encoder.Encode(decision)
+		case err == deposit.ErrAlreadyProcessed && s.duplicates == deposit.ReplaySkip:
+			continue
+		case err != nil:
+			err = encoder.Encode(newErrorResponse(line, err))
+		default:
+			err = encoder.Encode(decision)
+		}
+
+		// The client has gone away
+		if err != nil {
+			return
+		}
+	}
+
+	// The status has already been sent, so a body that cannot be read is reported in it
+	if err := scanner.Err(); err != nil {
+		encoder.Encode(errorResponse{Error: err.Error()})
+	}
+}
+
+// echo reports whether a deposit that has already been processed is answered with the
+// decision replayed by the validator
+func (s *Server) echo(decision deposit.Decision) bool {
+	return s.duplicates == deposit.ReplayEcho && decision.Replay
+}
+
+// handleHealth reports that the server is running
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, "ok\n")
+}
+
+// handleReady reports whether the server can receive traffic
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&s.stopping) != 0 {
+		http.Error(w, "stopping", http.StatusServiceUnavailable)
+		return
+	}
+
+	io.WriteString(w, "ok\n")
+}
+
+// handleMetrics reports the validated deposits remembered to detect duplicates, in the
+// Prometheus text format
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.validator.DedupeStats()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP deposit_dedupe_remembered Validated deposits remembered to detect duplicates.")
+	fmt.Fprintln(w, "# TYPE deposit_dedupe_remembered gauge")
+	fmt.Fprintf(w, "deposit_dedupe_remembered %d\n", stats.Remembered)
+
+	fmt.Fprintln(w, "# HELP deposit_dedupe_forgotten_total Validated deposits forgotten once they fell out of the dedupe window.")
+	fmt.Fprintln(w, "# TYPE deposit_dedupe_forgotten_total counter")
+	fmt.Fprintf(w, "deposit_dedupe_forgotten_total %d\n", stats.Forgotten)
+
+	horizon := int64(0)
+	if !stats.Horizon.IsZero() {
+		horizon = stats.Horizon.Unix()
+	}
+
+	fmt.Fprintln(w, "# HELP deposit_dedupe_horizon_seconds Deposits made before this Unix time have been forgotten.")
+	fmt.Fprintln(w, "# TYPE deposit_dedupe_horizon_seconds gauge")
+	fmt.Fprintf(w, "deposit_dedupe_horizon_seconds %d\n", horizon)
+}
+
+// validate parses and validates a deposit unless it has already been processed
+func (s *Server) validate(input string) (deposit.Decision, error) {
+	d, err := s.parse(input)
+	if err != nil {
+		return deposit.Decision{}, err
+	}
+
+	return s.validator.ValidateOnce(d)
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed string) {
+	w.Header().Set("Allow", allowed)
+	writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
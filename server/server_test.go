@@ -0,0 +1,241 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/travisbale/deposit-validator/deposit"
+)
+
+func post(s *Server, path string, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	return w
+}
+
+func TestServer_Deposits(t *testing.T) {
+	s := New(deposit.NewValidator())
+
+	t.Run("POST /deposits should return the decision", func(t *testing.T) {
+		w := post(s, "/deposits", `{"id":"1","customer_id":"1","load_amount":"$4000.00","time":"2000-01-01T00:00:00Z"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		assert.JSONEq(t, `{"id":"1","customer_id":"1","accepted":true}`, w.Body.String())
+	})
+
+	t.Run("POST /deposits should include the reasons for a rejection", func(t *testing.T) {
+		w := post(s, "/deposits", `{"id":"2","customer_id":"1","load_amount":"$4000.00","time":"2000-01-01T01:00:00Z"}`)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"id":"2","customer_id":"1","accepted":false,"reasons":["DAILY_AMOUNT_EXCEEDED"]}`, w.Body.String())
+	})
+
+	t.Run("POST /deposits should reject deposits that have already been processed", func(t *testing.T) {
+		w := post(s, "/deposits", `{"id":"1","customer_id":"1","load_amount":"$4000.00","time":"2000-01-01T00:00:00Z"}`)
+
+		assert.Equal(t, http.StatusConflict, w.Code)
+		assert.JSONEq(t, `{"error":"deposit has already been processed"}`, w.Body.String())
+	})
+
+	t.Run("POST /deposits should reject malformed deposits", func(t *testing.T) {
+		w := post(s, "/deposits", `{"id":"3","customer_id":"1","time":"2000-01-01T00:00:00Z"}`)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.JSONEq(t, `{"error":"missing load_amount"}`, w.Body.String())
+	})
+
+	t.Run("POST /deposits should describe the invalid fields of a deposit", func(t *testing.T) {
+		w := post(s, "/deposits", `{"id":"3","customer_id":"","load_amount":"$1.00","time":"2000-01-01T00:00:00Z"}`)
+
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+		assert.JSONEq(t, `{"error":"invalid deposit: customer_id is missing","invalid_fields":{"customer_id":"is missing"}}`, w.Body.String())
+	})
+
+	t.Run("GET /deposits should not be allowed", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/deposits", nil))
+
+		assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+		assert.Equal(t, http.MethodPost, w.Header().Get("Allow"))
+	})
+}
+
+func TestServer_Strict(t *testing.T) {
+	s := NewStrict(deposit.NewValidator())
+
+	t.Run("POST /deposits should reject unknown fields on a strict server", func(t *testing.T) {
+		w := post(s, "/deposits", `{"id":"1","customer_id":"1","load_amount":"$1.00","time":"2000-01-01T00:00:00Z","ParsedAmount":1}`)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+		assert.JSONEq(t, `{"error":"unknown field \"ParsedAmount\""}`, w.Body.String())
+	})
+
+	t.Run("POST /deposits/batch should reject unknown fields on a strict server", func(t *testing.T) {
+		w := post(s, "/deposits/batch", `{"id":"1","customer_id":"1","load_amount":"$1.00","time":"2000-01-01T00:00:00Z","note":""}`)
+		assert.Equal(t, `{"line":1,"error":"unknown field \"note\""}`+"\n", w.Body.String())
+	})
+}
+
+func TestServer_Batch(t *testing.T) {
+	s := New(deposit.NewValidator())
+
+	t.Run("POST /deposits/batch should respond to each deposit in order", func(t *testing.T) {
+		w := post(s, "/deposits/batch", strings.Join([]string{
+			`{"id":"1","customer_id":"1","load_amount":"$4000.00","time":"2000-01-01T00:00:00Z"}`,
+			`not json`,
+			`{"id":"1","customer_id":"1","load_amount":"$4000.00","time":"2000-01-01T00:00:00Z"}`,
+			`{"id":"2","customer_id":"1","load_amount":"$4000.00","time":"2000-01-01T01:00:00Z"}`,
+			`{"id":"","customer_id":"1","load_amount":"$1.00","time":"2000-01-01T02:00:00Z"}`,
+		}, "\n"))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+		assert.Equal(t, strings.Join([]string{
+			`{"id":"1","customer_id":"1","accepted":true}`,
+			`{"line":2,"error":"invalid character 'o' in literal null (expecting 'u')"}`,
+			`{"id":"2","customer_id":"1","accepted":false,"reasons":["DAILY_AMOUNT_EXCEEDED"]}`,
+			`{"line":5,"error":"invalid deposit: id is missing","invalid_fields":{"id":"is missing"}}`,
+		}, "\n")+"\n", w.Body.String())
+	})
+
+	t.Run("POST /deposits/batch should share limits with single deposits", func(t *testing.T) {
+		w := post(s, "/deposits", `{"id":"3","customer_id":"1","load_amount":"$1000.01","time":"2000-01-01T02:00:00Z"}`)
+		assert.JSONEq(t, `{"id":"3","customer_id":"1","accepted":false,"reasons":["DAILY_AMOUNT_EXCEEDED"]}`, w.Body.String())
+	})
+}
+
+func TestServer_Replay(t *testing.T) {
+	first := `{"id":"1","customer_id":"1","load_amount":"$4000.00","time":"2000-01-01T00:00:00Z"}`
+	second := `{"id":"2","customer_id":"1","load_amount":"$4000.00","time":"2000-01-01T01:00:00Z"}`
+
+	t.Run("POST /deposits should return the original decision in echo mode", func(t *testing.T) {
+		s := New(deposit.NewValidator())
+		s.SetReplayMode(deposit.ReplayEcho)
+		post(s, "/deposits", second)
+
+		w := post(s, "/deposits", second)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, `{"id":"2","customer_id":"1","accepted":true,"replay":true}`, w.Body.String())
+	})
+
+	t.Run("POST /deposits/batch should answer duplicates according to the replay mode", func(t *testing.T) {
+		body := strings.Join([]string{first, second, first, second}, "\n")
+		decisions := []string{
+			`{"id":"1","customer_id":"1","accepted":true}`,
+			`{"id":"2","customer_id":"1","accepted":false,"reasons":["DAILY_AMOUNT_EXCEEDED"]}`,
+		}
+
+		cases := map[deposit.ReplayMode][]string{
+			deposit.ReplaySkip: decisions,
+			deposit.ReplayEcho: append(decisions,
+				`{"id":"1","customer_id":"1","accepted":true,"replay":true}`,
+				`{"id":"2","customer_id":"1","accepted":false,"reasons":["DAILY_AMOUNT_EXCEEDED"],"replay":true}`,
+			),
+			deposit.ReplayError: append(decisions,
+				`{"line":3,"error":"deposit has already been processed"}`,
+				`{"line":4,"error":"deposit has already been processed"}`,
+			),
+		}
+
+		for mode, expected := range cases {
+			s := New(deposit.NewValidator())
+			s.SetReplayMode(mode)
+
+			w := post(s, "/deposits/batch", body)
+			assert.Equal(t, strings.Join(expected, "\n")+"\n", w.Body.String(), mode)
+		}
+	})
+}
+
+func TestServer_Concurrency(t *testing.T) {
+	s := New(deposit.NewValidator())
+
+	t.Run("POST /deposits should accept a deposit sent concurrently only once", func(t *testing.T) {
+		var wg sync.WaitGroup
+		codes := make([]int, 50)
+
+		for i := range codes {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				codes[i] = post(s, "/deposits", `{"id":"1","customer_id":"1","load_amount":"$1.00","time":"2000-01-01T00:00:00Z"}`).Code
+			}(i)
+		}
+		wg.Wait()
+
+		ok := 0
+		for _, code := range codes {
+			if code == http.StatusOK {
+				ok++
+			} else {
+				assert.Equal(t, http.StatusConflict, code)
+			}
+		}
+		assert.Equal(t, 1, ok)
+	})
+}
+
+func TestServer_Health(t *testing.T) {
+	s := New(deposit.NewValidator())
+
+	t.Run("GET /healthz should report the server is running", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("GET /readyz should report the server is ready until it is stopped", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		s.Stop()
+
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+		w = httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+func TestServer_Metrics(t *testing.T) {
+	policy := deposit.DefaultPolicy()
+	policy.DedupeWindow = 48 * time.Hour
+	s := New(deposit.NewValidatorWithPolicy(policy))
+
+	t.Run("GET /metrics should report the deposits remembered and forgotten", func(t *testing.T) {
+		post(s, "/deposits", `{"id":"1","customer_id":"1","load_amount":"$100.00","time":"2000-01-01T00:00:00Z"}`)
+		post(s, "/deposits", `{"id":"2","customer_id":"1","load_amount":"$100.00","time":"2000-01-04T00:00:00Z"}`)
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), "deposit_dedupe_remembered 1\n")
+		assert.Contains(t, w.Body.String(), "deposit_dedupe_forgotten_total 1\n")
+		assert.Contains(t, w.Body.String(), "deposit_dedupe_horizon_seconds 946771200\n")
+	})
+}
+
+func TestServer_HTTP(t *testing.T) {
+	ts := httptest.NewServer(New(deposit.NewValidator()))
+	defer ts.Close()
+
+	t.Run("Server should validate deposits over HTTP", func(t *testing.T) {
+		resp, err := http.Post(ts.URL+"/deposits", "application/json", strings.NewReader(`{"id":"1","customer_id":"1","load_amount":"$1.00","time":"2000-01-01T00:00:00Z"}`))
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+}
@@ -0,0 +1,109 @@
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// The migrations that create the schema, in order. A migration must never be changed once
+// it has been released, as databases that have already applied it will not apply it again.
+var migrations = []string{
+	// 1: customers, the deposits they have made and their accounts
+	`
+	CREATE TABLE customers (
+		customer_id TEXT PRIMARY KEY
+	);
+
+	CREATE TABLE validated_deposits (
+		customer_id TEXT NOT NULL,
+		deposit_id TEXT NOT NULL,
+		PRIMARY KEY (customer_id, deposit_id)
+	);
+
+	CREATE TABLE accounts (
+		customer_id TEXT NOT NULL,
+		currency TEXT NOT NULL,
+		latest BIGINT NOT NULL,
+		PRIMARY KEY (customer_id, currency)
+	);
+
+	CREATE TABLE account_entries (
+		customer_id TEXT NOT NULL,
+		currency TEXT NOT NULL,
+		time BIGINT NOT NULL,
+		amount BIGINT NOT NULL
+	);
+
+	CREATE INDEX account_entries_account ON account_entries (customer_id, currency, time);
+	`,
+
+	// 2: the time of each validated deposit, so that old deposits can be forgotten. Those
+	// validated before this have no time and are never forgotten.
+	`
+	ALTER TABLE validated_deposits ADD COLUMN time BIGINT;
+
+	CREATE INDEX validated_deposits_time ON validated_deposits (time);
+	`,
+
+	// 3: the decision made for each validated deposit, encoded as JSON, so that it can be
+	// given again when a deposit is repeated. Those validated before this have none.
+	`
+	ALTER TABLE validated_deposits ADD COLUMN decision TEXT;
+	`,
+
+	// 4: the time of the latest validated deposit forgotten for each customer, so that only
+	// their own deposits that may have been forgotten are rejected
+	`
+	CREATE TABLE forgotten_deposits (
+		customer_id TEXT PRIMARY KEY,
+		latest BIGINT NOT NULL
+	);
+	`,
+}
+
+// Migrate brings the database's schema up to date, applying each migration it is missing in
+// its own transaction
+func Migrate(db *sql.DB, dialect Dialect) error {
+	ctx := context.Background()
+
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return err
+	}
+
+	var version int
+	if err := db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+		return err
+	}
+
+	if version > len(migrations) {
+		return fmt.Errorf("database schema version %d is newer than this program supports", version)
+	}
+
+	for i := version; i < len(migrations); i++ {
+		if err := migrate(ctx, db, dialect, i+1, migrations[i]); err != nil {
+			return fmt.Errorf("migration %d failed: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+func migrate(ctx context.Context, db *sql.DB, dialect Dialect, version int, migration string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Recording the version first makes a concurrent migration of the same version fail
+	if _, err := tx.ExecContext(ctx, dialect.rebind(`INSERT INTO schema_migrations (version) VALUES (?)`), version); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, migration); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
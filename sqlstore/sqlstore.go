@@ -0,0 +1,340 @@
+// Package sqlstore keeps a deposit validator's state in a relational database, so that
+// several validators can share it. Any database/sql driver for a supported dialect can be
+// used; the application registers the driver and opens the database.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/travisbale/deposit-validator/deposit"
+)
+
+// A Dialect is a flavour of SQL
+type Dialect int
+
+const (
+	SQLite Dialect = iota
+	Postgres
+)
+
+// rebind replaces the ? placeholders in a query with those used by the dialect
+func (d Dialect) rebind(query string) string {
+	if d != Postgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+
+	for _, r := range query {
+		if r == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// Store keeps a validator's state in a database. Each update runs in a transaction that
+// starts by locking the customer's row, so that validators sharing the database check and
+// commit a customer's deposits one at a time.
+type Store struct {
+	db      *sql.DB
+	dialect Dialect
+}
+
+// Open migrates the database's schema and returns a store that keeps its state there
+func Open(db *sql.DB, dialect Dialect) (*Store, error) {
+	if err := Migrate(db, dialect); err != nil {
+		return nil, err
+	}
+
+	return &Store{db: db, dialect: dialect}, nil
+}
+
+func (s *Store) Update(customerID string, f func(tx deposit.Tx) error) error {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Writing the customer's row locks it until the transaction ends
+	_, err = tx.ExecContext(ctx, s.dialect.rebind(`
+		INSERT INTO customers (customer_id) VALUES (?)
+		ON CONFLICT (customer_id) DO UPDATE SET customer_id = excluded.customer_id`), customerID)
+	if err != nil {
+		return err
+	}
+
+	if err := f(&storeTx{ctx: ctx, tx: tx, dialect: s.dialect, customerID: customerID}); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *Store) View(customerID string, f func(tx deposit.Tx) error) error {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// Nothing is written, so the transaction is always rolled back
+	defer tx.Rollback()
+
+	return f(&storeTx{ctx: ctx, tx: tx, dialect: s.dialect, customerID: customerID})
+}
+
+// Close does nothing, as the database belongs to the caller
+func (s *Store) Close() error {
+	return nil
+}
+
+// ForgetValidated records the latest deposit each customer has forgotten and deletes the
+// deposits in the same transaction, so that a deposit is never forgotten without a record
+func (s *Store) ForgetValidated(before time.Time) (int, error) {
+	ctx := context.Background()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, s.dialect.rebind(`
+		INSERT INTO forgotten_deposits (customer_id, latest)
+		SELECT customer_id, MAX(time) FROM validated_deposits WHERE time < ? GROUP BY customer_id
+		ON CONFLICT (customer_id) DO UPDATE SET latest = CASE
+			WHEN excluded.latest > forgotten_deposits.latest THEN excluded.latest
+			ELSE forgotten_deposits.latest
+		END`), before.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := tx.ExecContext(ctx, s.dialect.rebind(`
+		DELETE FROM validated_deposits WHERE time < ?`), before.UnixNano())
+	if err != nil {
+		return 0, err
+	}
+
+	forgotten, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(forgotten), tx.Commit()
+}
+
+func (s *Store) CountValidated() (int, error) {
+	var n int
+	err := s.db.QueryRowContext(context.Background(), `SELECT COUNT(*) FROM validated_deposits`).Scan(&n)
+	return n, err
+}
+
+// A storeTx reads and changes a customer's state in a database transaction
+type storeTx struct {
+	ctx        context.Context
+	tx         *sql.Tx
+	dialect    Dialect
+	customerID string
+}
+
+func (tx *storeTx) exec(query string, args ...interface{}) error {
+	_, err := tx.tx.ExecContext(tx.ctx, tx.dialect.rebind(query), args...)
+	return err
+}
+
+// Validated looks the deposit up by its key. Keys without a customer are saved with an empty
+// customer ID, which no customer has, so that the primary key keeps their IDs unique.
+func (tx *storeTx) Validated(key deposit.DedupeKey) (bool, error) {
+	var n int
+
+	err := tx.tx.QueryRowContext(tx.ctx, tx.dialect.rebind(`
+		SELECT COUNT(*) FROM validated_deposits WHERE customer_id = ? AND deposit_id = ?`), key.CustomerID, key.DepositID).Scan(&n)
+
+	return n > 0, err
+}
+
+// MarkValidated inserts the deposit's key unless it is already there. A transaction inserting
+// the same key waits for this one to end, and then inserts nothing if it committed.
+func (tx *storeTx) MarkValidated(key deposit.DedupeKey, at time.Time) (bool, error) {
+	result, err := tx.tx.ExecContext(tx.ctx, tx.dialect.rebind(`
+		INSERT INTO validated_deposits (customer_id, deposit_id, time) VALUES (?, ?, ?)
+		ON CONFLICT (customer_id, deposit_id) DO NOTHING`), key.CustomerID, key.DepositID, at.UnixNano())
+	if err != nil {
+		return false, err
+	}
+
+	inserted, err := result.RowsAffected()
+	return inserted > 0, err
+}
+
+func (tx *storeTx) SaveDecision(key deposit.DedupeKey, decision deposit.Decision) error {
+	data, err := json.Marshal(decision)
+	if err != nil {
+		return err
+	}
+
+	return tx.exec(`
+		UPDATE validated_deposits SET decision = ? WHERE customer_id = ? AND deposit_id = ?`, string(data), key.CustomerID, key.DepositID)
+}
+
+func (tx *storeTx) Decision(key deposit.DedupeKey) (*deposit.Decision, error) {
+	var data sql.NullString
+
+	err := tx.tx.QueryRowContext(tx.ctx, tx.dialect.rebind(`
+		SELECT decision FROM validated_deposits WHERE customer_id = ? AND deposit_id = ?`), key.CustomerID, key.DepositID).Scan(&data)
+
+	switch {
+	case err == sql.ErrNoRows || (err == nil && !data.Valid):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	var decision deposit.Decision
+	if err := json.Unmarshal([]byte(data.String), &decision); err != nil {
+		return nil, fmt.Errorf("invalid decision saved for deposit %s: %w", key, err)
+	}
+
+	return &decision, nil
+}
+
+func (tx *storeTx) Forgotten(key deposit.DedupeKey) (time.Time, error) {
+	var latest int64
+
+	err := tx.tx.QueryRowContext(tx.ctx, tx.dialect.rebind(`
+		SELECT latest FROM forgotten_deposits WHERE customer_id = ?`), key.CustomerID).Scan(&latest)
+
+	switch {
+	case err == sql.ErrNoRows:
+		return time.Time{}, nil
+	case err != nil:
+		return time.Time{}, err
+	}
+
+	return fromUnixNano(latest), nil
+}
+
+func (tx *storeTx) Account(currency string) (*deposit.Account, error) {
+	account := &deposit.Account{CustomerID: tx.customerID, Currency: currency}
+
+	var latest int64
+	err := tx.tx.QueryRowContext(tx.ctx, tx.dialect.rebind(`
+		SELECT latest FROM accounts WHERE customer_id = ? AND currency = ?`), tx.customerID, currency).Scan(&latest)
+
+	switch {
+	case err == sql.ErrNoRows:
+		return account, nil
+	case err != nil:
+		return nil, err
+	}
+
+	account.Latest = fromUnixNano(latest)
+	account.History, err = tx.history(currency)
+	if err != nil {
+		return nil, err
+	}
+
+	return account, nil
+}
+
+func (tx *storeTx) Accounts() ([]*deposit.Account, error) {
+	rows, err := tx.tx.QueryContext(tx.ctx, tx.dialect.rebind(`
+		SELECT currency FROM accounts WHERE customer_id = ? ORDER BY currency`), tx.customerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var currencies []string
+	for rows.Next() {
+		var currency string
+		if err := rows.Scan(&currency); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		currencies = append(currencies, currency)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	accounts := make([]*deposit.Account, len(currencies))
+	for i, currency := range currencies {
+		if accounts[i], err = tx.Account(currency); err != nil {
+			return nil, err
+		}
+	}
+
+	return accounts, nil
+}
+
+// history returns the entries of an account, oldest first
+func (tx *storeTx) history(currency string) ([]deposit.Entry, error) {
+	rows, err := tx.tx.QueryContext(tx.ctx, tx.dialect.rebind(`
+		SELECT time, amount FROM account_entries WHERE customer_id = ? AND currency = ? ORDER BY time`), tx.customerID, currency)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []deposit.Entry
+	for rows.Next() {
+		var t, amount int64
+		if err := rows.Scan(&t, &amount); err != nil {
+			return nil, err
+		}
+
+		history = append(history, deposit.Entry{Time: fromUnixNano(t), Amount: deposit.Money(amount)})
+	}
+
+	return history, rows.Err()
+}
+
+// SaveAccount replaces the account's entries, which are few as the history is pruned
+func (tx *storeTx) SaveAccount(account *deposit.Account) error {
+	err := tx.exec(`
+		INSERT INTO accounts (customer_id, currency, latest) VALUES (?, ?, ?)
+		ON CONFLICT (customer_id, currency) DO UPDATE SET latest = excluded.latest`, tx.customerID, account.Currency, account.Latest.UnixNano())
+	if err != nil {
+		return err
+	}
+
+	err = tx.exec(`DELETE FROM account_entries WHERE customer_id = ? AND currency = ?`, tx.customerID, account.Currency)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range account.History {
+		err := tx.exec(`
+			INSERT INTO account_entries (customer_id, currency, time, amount) VALUES (?, ?, ?, ?)`, tx.customerID, account.Currency, entry.Time.UnixNano(), int64(entry.Amount))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Times are stored as nanoseconds since the Unix epoch, which every database can compare
+func fromUnixNano(n int64) time.Time {
+	return time.Unix(0, n).UTC()
+}
@@ -0,0 +1,372 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+	"github.com/travisbale/deposit-validator/deposit"
+)
+
+// openSQLite opens a new SQLite database, waiting rather than failing when another
+// connection holds the lock
+func openSQLite(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "deposits.db")+"?_busy_timeout=10000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return db
+}
+
+// openPostgres creates a schema of its own in the Postgres database and opens the database
+// with it as the search path, dropping the schema when the test ends
+func openPostgres(t *testing.T, dsn string) *sql.DB {
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := fmt.Sprintf("deposit_validator_test_%d", time.Now().UnixNano())
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatal(err)
+	}
+
+	// Unknown connection parameters are set at run time by the driver
+	if strings.Contains(dsn, "://") && strings.Contains(dsn, "?") {
+		dsn += "&search_path=" + schema
+	} else if strings.Contains(dsn, "://") {
+		dsn += "?search_path=" + schema
+	} else {
+		dsn += " search_path=" + schema
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+	})
+
+	return db
+}
+
+// A database the tests run against
+type database struct {
+	*sql.DB
+	dialect Dialect
+}
+
+// forEachDatabase runs the test against a new SQLite database, and against the Postgres
+// database at DV_POSTGRES_DSN if it is set
+func forEachDatabase(t *testing.T, test func(t *testing.T, db database)) {
+	t.Run("SQLite", func(t *testing.T) {
+		test(t, database{openSQLite(t), SQLite})
+	})
+
+	t.Run("Postgres", func(t *testing.T) {
+		dsn := os.Getenv("DV_POSTGRES_DSN")
+		if dsn == "" {
+			t.Skip("DV_POSTGRES_DSN is not set")
+		}
+
+		test(t, database{openPostgres(t, dsn), Postgres})
+	})
+}
+
+func openStore(t *testing.T, db database) *Store {
+	s, err := Open(db.DB, db.dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func newDeposit(id string, customerID string, amount string, t time.Time) *deposit.Deposit {
+	return &deposit.Deposit{ID: id, CustomerID: customerID, Amount: amount, Time: t}
+}
+
+func TestMigrate(t *testing.T) {
+	forEachDatabase(t, testMigrate)
+}
+
+func testMigrate(t *testing.T, db database) {
+
+	t.Run("Migrate should create the schema", func(t *testing.T) {
+		assert.NoError(t, Migrate(db.DB, db.dialect))
+
+		var version int
+		assert.NoError(t, db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version))
+		assert.Equal(t, len(migrations), version)
+	})
+
+	t.Run("Migrate should do nothing if the schema is up to date", func(t *testing.T) {
+		assert.NoError(t, Migrate(db.DB, db.dialect))
+	})
+
+	t.Run("Migrate should return an error for a newer schema", func(t *testing.T) {
+		_, err := db.Exec(db.dialect.rebind(`INSERT INTO schema_migrations (version) VALUES (?)`), len(migrations)+1)
+		assert.NoError(t, err)
+		assert.Error(t, Migrate(db.DB, db.dialect))
+	})
+}
+
+func TestStore(t *testing.T) {
+	forEachDatabase(t, testStore)
+}
+
+func testStore(t *testing.T, db database) {
+	s := openStore(t, db)
+	base := time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC)
+
+	t.Run("Update should save the customer's state", func(t *testing.T) {
+		err := s.Update("1", func(tx deposit.Tx) error {
+			account, err := tx.Account("USD")
+			assert.NoError(t, err)
+			assert.Empty(t, account.History)
+
+			account.Latest = base
+			account.History = []deposit.Entry{{Time: base.Add(-time.Hour), Amount: 100}, {Time: base, Amount: 200}}
+			assert.NoError(t, tx.SaveAccount(account))
+			_, err = tx.MarkValidated(deposit.DedupeKey{CustomerID: "1", DepositID: "10"}, base)
+			return err
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("View should read the customer's state", func(t *testing.T) {
+		s.View("1", func(tx deposit.Tx) error {
+			validated, err := tx.Validated(deposit.DedupeKey{CustomerID: "1", DepositID: "10"})
+			assert.NoError(t, err)
+			assert.True(t, validated)
+
+			accounts, err := tx.Accounts()
+			assert.NoError(t, err)
+			assert.Equal(t, []*deposit.Account{{
+				CustomerID: "1",
+				Currency:   "USD",
+				Latest:     base,
+				History:    []deposit.Entry{{Time: base.Add(-time.Hour), Amount: 100}, {Time: base, Amount: 200}},
+			}}, accounts)
+			return nil
+		})
+	})
+
+	t.Run("Update should not save the changes if it fails", func(t *testing.T) {
+		err := s.Update("1", func(tx deposit.Tx) error {
+			tx.MarkValidated(deposit.DedupeKey{CustomerID: "1", DepositID: "11"}, base)
+			return fmt.Errorf("failed")
+		})
+		assert.EqualError(t, err, "failed")
+
+		s.View("1", func(tx deposit.Tx) error {
+			validated, _ := tx.Validated(deposit.DedupeKey{CustomerID: "1", DepositID: "11"})
+			assert.False(t, validated)
+			return nil
+		})
+	})
+
+	t.Run("ForgetValidated should forget deposits made before the time", func(t *testing.T) {
+		s.Update("2", func(tx deposit.Tx) error {
+			tx.MarkValidated(deposit.DedupeKey{CustomerID: "2", DepositID: "20"}, base.Add(-48*time.Hour))
+			_, err := tx.MarkValidated(deposit.DedupeKey{CustomerID: "2", DepositID: "21"}, base)
+			return err
+		})
+
+		// Deposits validated before their times were recorded are kept
+		_, err := s.db.Exec(`INSERT INTO validated_deposits (customer_id, deposit_id) VALUES ('2', '19')`)
+		assert.NoError(t, err)
+
+		count, err := s.CountValidated()
+		assert.NoError(t, err)
+		assert.Equal(t, 4, count)
+
+		forgotten, err := s.ForgetValidated(base.Add(-time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, 1, forgotten)
+
+		s.View("2", func(tx deposit.Tx) error {
+			for id, expected := range map[string]bool{"19": true, "20": false, "21": true} {
+				validated, _ := tx.Validated(deposit.DedupeKey{CustomerID: "2", DepositID: id})
+				assert.Equal(t, expected, validated, id)
+			}
+			return nil
+		})
+	})
+
+	t.Run("ForgetValidated should record the latest deposit each customer has forgotten", func(t *testing.T) {
+		s.Update("2", func(tx deposit.Tx) error {
+			_, err := tx.MarkValidated(deposit.DedupeKey{CustomerID: "2", DepositID: "22"}, base.Add(-24*time.Hour))
+			return err
+		})
+
+		_, err := s.ForgetValidated(base.Add(-time.Hour))
+		assert.NoError(t, err)
+
+		// Forgetting nothing later keeps the latest time
+		_, err = s.ForgetValidated(base.Add(-72 * time.Hour))
+		assert.NoError(t, err)
+
+		s.View("2", func(tx deposit.Tx) error {
+			forgotten, err := tx.Forgotten(deposit.DedupeKey{CustomerID: "2", DepositID: "22"})
+			assert.NoError(t, err)
+			assert.Equal(t, base.Add(-24*time.Hour), forgotten)
+			return nil
+		})
+
+		s.View("1", func(tx deposit.Tx) error {
+			forgotten, err := tx.Forgotten(deposit.DedupeKey{CustomerID: "1", DepositID: "22"})
+			assert.NoError(t, err)
+			assert.True(t, forgotten.IsZero())
+			return nil
+		})
+	})
+}
+
+func TestStore_Validator(t *testing.T) {
+	forEachDatabase(t, testStoreValidator)
+}
+
+func testStoreValidator(t *testing.T, db database) {
+
+	t.Run("Validators sharing a database should share their state", func(t *testing.T) {
+		first := deposit.NewValidatorWithStore(deposit.DefaultPolicy(), openStore(t, db))
+		second := deposit.NewValidatorWithStore(deposit.DefaultPolicy(), openStore(t, db))
+
+		assert.True(t, first.Validate(newDeposit("1", "1", "$3000.00", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC))).Accepted)
+		assert.Equal(t, []deposit.Reason{deposit.DailyAmountExceeded}, second.Validate(newDeposit("2", "1", "$3000.00", time.Date(2021, 1, 9, 11, 0, 0, 0, time.UTC))).Reasons)
+
+		_, err := second.ValidateOnce(newDeposit("1", "1", "$3000.00", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC)))
+		assert.Equal(t, deposit.ErrAlreadyProcessed, err)
+	})
+
+	t.Run("Validators sharing a database should replay the decisions made by each other", func(t *testing.T) {
+		first := deposit.NewValidatorWithStore(deposit.DefaultPolicy(), openStore(t, db))
+		second := deposit.NewValidatorWithStore(deposit.DefaultPolicy(), openStore(t, db))
+
+		original, err := first.ValidateOnce(newDeposit("1", "3", "$6000.00", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC)))
+		assert.NoError(t, err)
+
+		decision, err := second.ValidateOnce(newDeposit("1", "3", "$6000.00", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC)))
+		assert.Equal(t, deposit.ErrAlreadyProcessed, err)
+		assert.Equal(t, deposit.Decision{ID: "1", CustomerID: "3", Reasons: original.Reasons, Replay: true}, decision)
+	})
+
+	t.Run("Validators sharing a database should check and commit each deposit atomically", func(t *testing.T) {
+		validators := []deposit.Validator{
+			deposit.NewValidatorWithStore(deposit.DefaultPolicy(), openStore(t, db)),
+			deposit.NewValidatorWithStore(deposit.DefaultPolicy(), openStore(t, db)),
+		}
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		accepted, validated := 0, 0
+
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				// Half of the deposits are duplicates of the other half
+				d := newDeposit(fmt.Sprint(i/2), "2", "$1.00", time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC))
+				decision, err := validators[i%2].ValidateOnce(d)
+				assert.True(t, err == nil || err == deposit.ErrAlreadyProcessed, "%v", err)
+
+				mu.Lock()
+				defer mu.Unlock()
+				if err == nil {
+					validated++
+				}
+				if err == nil && decision.Accepted {
+					accepted++
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		assert.Equal(t, 10, validated)
+		assert.Equal(t, 3, accepted)
+	})
+
+	t.Run("Usage should read the accounts from the database", func(t *testing.T) {
+		v := deposit.NewValidatorWithStore(deposit.DefaultPolicy(), openStore(t, db))
+
+		usage, err := v.Usage("1", time.Time{})
+		assert.NoError(t, err)
+		assert.Len(t, usage, 1)
+		assert.Equal(t, deposit.Ledger{Start: time.Date(2021, 1, 9, 0, 0, 0, 0, time.UTC), Deposits: 1, Total: 3000 * deposit.Dollar}, usage[0].Daily)
+	})
+}
+
+func TestDialect_Rebind(t *testing.T) {
+	t.Run("rebind should number the placeholders for Postgres", func(t *testing.T) {
+		assert.Equal(t, "SELECT $1, $2", Postgres.rebind("SELECT ?, ?"))
+		assert.Equal(t, "SELECT ?, ?", SQLite.rebind("SELECT ?, ?"))
+	})
+}
+
+func TestStore_DedupeScope(t *testing.T) {
+	forEachDatabase(t, testStoreDedupeScope)
+}
+
+func testStoreDedupeScope(t *testing.T, db database) {
+	base := time.Date(2021, 1, 9, 10, 0, 0, 0, time.UTC)
+
+	t.Run("ValidateOnce should not confuse IDs that contain separators", func(t *testing.T) {
+		v := deposit.NewValidatorWithStore(deposit.DefaultPolicy(), openStore(t, db))
+
+		for _, d := range []*deposit.Deposit{newDeposit("1-2", "3", "$1.00", base), newDeposit("1", "2-3", "$1.00", base)} {
+			_, err := v.ValidateOnce(d)
+			assert.NoError(t, err)
+
+			_, err = v.ValidateOnce(d)
+			assert.Equal(t, deposit.ErrAlreadyProcessed, err)
+		}
+	})
+
+	t.Run("Validators sharing a database should accept an ID only once in the global scope", func(t *testing.T) {
+		policy := deposit.DefaultPolicy()
+		policy.DedupeScope = deposit.GlobalScope
+		validators := []deposit.Validator{
+			deposit.NewValidatorWithStore(policy, openStore(t, db)),
+			deposit.NewValidatorWithStore(policy, openStore(t, db)),
+		}
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		validated := 0
+
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+
+				// Each customer sends a deposit with the same ID
+				_, err := validators[i%2].ValidateOnce(newDeposit("global", fmt.Sprint(100+i), "$1.00", base))
+				assert.True(t, err == nil || err == deposit.ErrAlreadyProcessed, "%v", err)
+
+				mu.Lock()
+				defer mu.Unlock()
+				if err == nil {
+					validated++
+				}
+			}(i)
+		}
+		wg.Wait()
+
+		assert.Equal(t, 1, validated)
+	})
+}